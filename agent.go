@@ -1,159 +1,480 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/sashabaranov/go-openai"
 )
 
 type Agent struct {
-	Config  *Config
-	Session *Session
-	Client  *openai.Client
+	Config   *Config
+	Session  *Session
+	Provider Provider
+
+	runner   Runner
+	shell    *PersistentShell
+	policy   *Policy
+	approved map[string]bool
+	stdin    *bufio.Reader
+
+	retryAfter *retryAfterTransport
+	usage      usageTotals
+
+	// Providers of the endpoints other than the main one, the endpoints
+	// that failed during the current run, and its failed commands.
+	httpClient     *http.Client
+	providers      map[ModelEndpoint]Provider
+	down           map[ModelEndpoint]bool
+	failedCommands int
+
+	snapshots       *Snapshots
+	snapshotsFailed bool
+
+	// budgetWarned is set once the session was found too large to compact
+	// under context_budget.
+	budgetWarned bool
+
+	// Events receives the structured events of a run. When it is set,
+	// nothing is printed to stdout.
+	Events func(RunEvent)
+
+	// NonInteractive denies commands that need approval instead of asking
+	// on the terminal.
+	NonInteractive bool
 }
 
 func NewAgent(config *Config, session *Session) *Agent {
-	clientConfig := openai.DefaultConfig(config.Settings.APIKey)
-	if config.Settings.APIURL != "" {
-		clientConfig.BaseURL = config.Settings.APIURL
-	}
-	client := openai.NewClientWithConfig(clientConfig)
+	retryAfter := &retryAfterTransport{}
+	client := &http.Client{Transport: retryAfter}
 
 	return &Agent{
-		Config:  config,
-		Session: session,
-		Client:  client,
+		Config:     config,
+		Session:    session,
+		Provider:   NewProvider(config.Settings, client),
+		retryAfter: retryAfter,
+		httpClient: client,
 	}
 }
 
-func (a *Agent) Run(prompt string) error {
+// cancelledResult is recorded for tool calls interrupted by the user, so the
+// session stays valid for the next request.
+const cancelledResult = "Command cancelled by user."
+
+// display holds which parts of a run are printed.
+type display struct {
+	call, result, reasoning bool
+}
+
+func (d display) any() bool {
+	return d.call || d.result || d.reasoning
+}
+
+func (a *Agent) display() display {
+	if a.Events != nil {
+		return display{}
+	}
 	verbose := a.Config.Settings.Verbose
-	showCall := verbose || a.Config.Settings.ShowToolCall
-	showResult := verbose || a.Config.Settings.ShowToolResult
-	showReasoning := verbose || a.Config.Settings.ShowReasoning
-	anyShow := showCall || showResult || showReasoning
+	return display{
+		call:      verbose || a.Config.Settings.ShowToolCall,
+		result:    verbose || a.Config.Settings.ShowToolResult,
+		reasoning: verbose || a.Config.Settings.ShowReasoning,
+	}
+}
 
-	if err := a.Session.AddMessage(openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
-		Content: prompt,
-	}); err != nil {
+var tools = []Tool{
+	{
+		Name:        "bash",
+		Description: "Execute a bash command and get the output.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"command": {"type": "string", "description": "The command to run."},
+				"timeout": {"type": "integer", "description": "The timeout in seconds. Default is no timeout."}
+			},
+			"required": ["command"]
+		}`),
+	},
+}
+
+// Run sends prompt to the model and executes its tool calls until it
+// answers without any. Cancelling ctx aborts the current request or command.
+// When a budget runs out, the model is asked for a final summary without
+// tools and a *BudgetError is returned.
+func (a *Agent) Run(ctx context.Context, prompt string) error {
+	show := a.display()
+
+	if a.policy == nil {
+		policy, err := NewPolicy(a.Config.Settings.Policy)
+		if err != nil {
+			return err
+		}
+		a.policy = policy
+	}
+	if a.runner == nil {
+		runner, err := NewRunner(a.Config)
+		if err != nil {
+			return err
+		}
+		a.runner = runner
+	}
+
+	lock, err := a.Session.Lock(ctx, a.Config.Settings.SessionWait)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+	// Another process may have added to the session since it was loaded.
+	if err := a.Session.loadMessages(); err != nil {
 		return err
 	}
+	if err := a.Session.closeToolCalls(); err != nil {
+		return err
+	}
+	a.emit(RunEvent{Type: RunStart, Session: filepath.Base(a.Session.LogFile), Prompt: prompt})
+	a.down = nil
+	a.failedCommands = 0
 
-	tools := []openai.Tool{
-		{
-			Type: openai.ToolTypeFunction,
-			Function: &openai.FunctionDefinition{
-				Name:        "bash",
-				Description: "Execute a bash command and get the output.",
-				Parameters: json.RawMessage(`{
-					"type": "object",
-					"properties": {
-						"command": {"type": "string", "description": "The command to run."},
-						"timeout": {"type": "integer", "description": "The timeout in seconds. Default is no timeout."}
-					},
-					"required": ["command"]
-				}`),
-			},
-		},
+	b, err := newBudget(a.Config.Settings)
+	if err != nil {
+		return err
+	}
+	// runCtx also expires with the time budget; ctx is only cancelled by
+	// the user.
+	runCtx := ctx
+	if !b.deadline.IsZero() {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithDeadline(ctx, b.deadline)
+		defer cancel()
+	}
+	timedOut := func() bool {
+		return ctx.Err() == nil && runCtx.Err() != nil
 	}
 
+	if err := a.Session.AddMessage(Message{
+		Role:    RoleUser,
+		Content: prompt,
+	}); err != nil {
+		return err
+	}
+	defer a.updateMeta(ctx)
+
 	for {
-		resp, err := a.Client.CreateChatCompletion(
-			context.Background(),
-			openai.ChatCompletionRequest{
-				Model:    a.Config.Settings.Model,
-				Messages: a.Session.Messages,
-				Tools:    tools,
-			},
-		)
+		if stop := b.exceeded(time.Now()); stop != nil {
+			return a.stop(ctx, b, stop, show)
+		}
+
+		// Compaction requests count toward the token budget too.
+		before := a.usage.Total
+		err := a.maybeCompact(runCtx)
+		b.tokens += a.usage.Total - before
 		if err != nil {
+			if timedOut() {
+				continue
+			}
+			return err
+		}
+
+		req := CompletionRequest{
+			Messages: a.Session.Messages,
+			Tools:    a.tools(),
+		}
+		resp, latency, err := a.complete(runCtx, req, PurposeMain, show)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if timedOut() {
+				continue
+			}
 			return err
 		}
+		b.tokens += resp.Usage.TotalTokens
+		msg := resp.Message
+		if a.Config.Settings.TextTools && len(msg.ToolCalls) == 0 {
+			msg.ToolCalls = parseTextToolCalls(msg.Content)
+		}
 
-		msg := resp.Choices[0].Message
 		if err := a.Session.AddMessage(msg); err != nil {
 			return err
 		}
+		if err := a.recordUsage(resp.Model, resp.Usage, latency, ""); err != nil {
+			return err
+		}
+		a.printMessage(msg, show)
 
-		if showReasoning && msg.ReasoningContent != "" {
-			fmt.Printf("[REASONING]\n%s\n", msg.ReasoningContent)
+		if len(msg.ToolCalls) == 0 {
+			break
 		}
+		b.turns++
+		a.usage.ToolCalls += len(msg.ToolCalls)
 
-		if msg.Content != "" {
-			if anyShow {
-				fmt.Print("[MESSAGE]\n")
+		if err := a.runToolCalls(ctx, runCtx, msg.ToolCalls, show); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// timeBudgetResult is recorded for tool calls stopped by the time budget.
+const timeBudgetResult = "Command stopped: the time limit for this task was reached."
+
+// interruptedResult returns the tool result for a command that was stopped
+// before it finished, either by the user or by the time budget.
+func interruptedResult(ctx context.Context) string {
+	if ctx.Err() != nil {
+		return cancelledResult
+	}
+	return timeBudgetResult
+}
+
+// complete sends req to the models for purpose, falling back to the next
+// one when a model keeps failing. Transient failures are retried, and main
+// and summary requests are streamed if configured. It returns how long the
+// successful attempt took.
+func (a *Agent) complete(ctx context.Context, req CompletionRequest, purpose string, show display) (Completion, time.Duration, error) {
+	if len(req.Tools) > 0 && a.parallelLimit() > 1 {
+		req = parallelToolRequest(req)
+	}
+	if a.Config.Settings.TextTools {
+		req = textToolRequest(req)
+	}
+
+	// Endpoints that failed earlier in the run are skipped, unless all did.
+	chain := a.modelChain(purpose)
+	if up := slices.DeleteFunc(slices.Clone(chain), func(ep ModelEndpoint) bool { return a.down[ep] }); len(up) > 0 {
+		chain = up
+	}
+
+	var err error
+	for i, ep := range chain {
+		req.Model = ep.Model
+		var resp Completion
+		var latency time.Duration
+		resp, latency, err = a.completeWith(ctx, a.providerFor(ep), req, purpose, show)
+		if err == nil {
+			resp.Model = ep.Model
+			resp.Message.Model = ep.Model
+			return resp, latency, nil
+		}
+		if ctx.Err() != nil {
+			return resp, latency, err
+		}
+		if a.down == nil {
+			a.down = map[ModelEndpoint]bool{}
+		}
+		a.down[ep] = true
+		if i+1 < len(chain) {
+			fmt.Fprintf(os.Stderr, "Model %s failed (%v), falling back to %s.\n", ep.Model, err, chain[i+1].Model)
+		}
+	}
+	return Completion{}, 0, err
+}
+
+// completeWith sends req to provider, retrying transient failures.
+func (a *Agent) completeWith(ctx context.Context, provider Provider, req CompletionRequest, purpose string, show display) (Completion, time.Duration, error) {
+	var resp Completion
+	var latency time.Duration
+	err := a.withRetry(ctx, func(ctx context.Context) error {
+		start := time.Now()
+		defer func() { latency = time.Since(start) }()
+
+		var onDelta DeltaFunc
+		if a.Config.Settings.Stream && (purpose == PurposeMain || purpose == PurposeSummary) {
+			printer := &streamPrinter{
+				w:             os.Stdout,
+				showReasoning: show.reasoning,
+				showHeader:    show.any(),
 			}
-			out := msg.Content
-			if !strings.HasSuffix(out, "\n") {
-				out += "\n"
+			if a.Events != nil {
+				printer.emit = a.emit
+				printer.showReasoning = true
 			}
-			fmt.Print(out)
-		}
-
-		if len(msg.ToolCalls) > 0 {
-			for _, tc := range msg.ToolCalls {
-				if tc.Function.Name == "bash" {
-					var args struct {
-						Command string `json:"command"`
-						Timeout int    `json:"timeout"`
-					}
-					if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
-						return err
-					}
-
-					if showCall {
-						fmt.Printf("[TOOL] %s\n", args.Command)
-					}
-
-					timeout := time.Duration(args.Timeout) * time.Second
-					res, err := ExecuteBash(args.Command, a.Config.ProjectRoot, timeout)
-					var result string
-					if err != nil {
-						result = fmt.Sprintf("Error executing bash: %v", err)
-					} else {
-						stdout, err := a.handleOutput(res.Stdout, a.Config.Settings.MaxStdout, "stdout")
-						if err != nil {
-							fmt.Fprintf(os.Stderr, "Error handling stdout: %v\n", err)
-							stdout = res.Stdout
-						}
-
-						stderr, err := a.handleOutput(res.Stderr, a.Config.Settings.MaxStderr, "stderr")
-						if err != nil {
-							fmt.Fprintf(os.Stderr, "Error handling stderr: %v\n", err)
-							stderr = res.Stderr
-						}
-
-						result = fmt.Sprintf("Exit Code: %d\nSTDOUT:\n%s\nSTDERR:\n%s",
-							res.ExitCode, stdout, stderr)
-					}
-
-					if showResult {
-						fmt.Printf("[RESULT]\n%s\n", result)
-					}
-
-					if err := a.Session.AddMessage(openai.ChatCompletionMessage{
-						Role:       openai.ChatMessageRoleTool,
-						Content:    result,
-						ToolCallID: tc.ID,
-					}); err != nil {
-						return err
-					}
-				}
+			defer printer.finish()
+			onDelta = func(reasoning, content string) {
+				printer.reasoning(reasoning)
+				printer.content(content)
 			}
-		} else {
-			break
 		}
+		var err error
+		resp, err = provider.Complete(ctx, req, onDelta)
+		return err
+	})
+	return resp, latency, err
+}
+
+// recordUsage stores the usage of a request to model with the session and
+// adds it to the totals of this agent.
+func (a *Agent) recordUsage(model string, usage Usage, latency time.Duration, purpose string) error {
+	ev := newUsageEvent(model, usage, latency)
+	ev.Purpose = purpose
+	a.usage.add(ev, a.Config.Settings.Prices)
+	a.emit(RunEvent{Type: RunUsage, Usage: &ev})
+	return a.Session.AddUsage(ev)
+}
+
+// Usage returns a one-line summary of the requests made by this agent.
+func (a *Agent) Usage() string {
+	return a.usage.summary()
+}
+
+// printMessage prints a non-streamed answer; streamed ones were printed
+// while they arrived. With Events, the complete answer is emitted instead.
+func (a *Agent) printMessage(msg Message, show display) {
+	if a.Events != nil {
+		if msg.ReasoningContent != "" {
+			a.emit(RunEvent{Type: RunReasoning, Content: msg.ReasoningContent})
+		}
+		if msg.Content != "" {
+			a.emit(RunEvent{Type: RunMessage, Content: msg.Content})
+		}
+		return
+	}
+	if a.Config.Settings.Stream {
+		return
 	}
 
-	return nil
+	if show.reasoning && msg.ReasoningContent != "" {
+		fmt.Printf("[REASONING]\n%s\n", msg.ReasoningContent)
+	}
+
+	if msg.Content != "" {
+		if show.any() {
+			fmt.Print("[MESSAGE]\n")
+		}
+		out := msg.Content
+		if !strings.HasSuffix(out, "\n") {
+			out += "\n"
+		}
+		fmt.Print(out)
+	}
+}
+
+// stop records why the run stopped and gives the model one last turn
+// without tools to summarize its progress.
+func (a *Agent) stop(ctx context.Context, b *budget, stop *BudgetError, show display) error {
+	fmt.Fprintf(os.Stderr, "Budget reached (%s). Asking for a final summary...\n", stop.describe())
+
+	if err := a.Session.AddEvent(StopEvent{
+		Type:   EventStop,
+		Time:   time.Now(),
+		Reason: stop.Reason,
+		Limit:  stop.Limit,
+		Turns:  b.turns,
+		Tokens: b.tokens,
+	}); err != nil {
+		return err
+	}
+
+	if err := a.Session.AddMessage(Message{
+		Role:    RoleUser,
+		Content: fmt.Sprintf(budgetPrompt, stop.describe()),
+	}); err != nil {
+		return err
+	}
+
+	resp, latency, err := a.complete(ctx, CompletionRequest{
+		Messages: a.Session.Messages,
+	}, PurposeSummary, show)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	msg := resp.Message
+	// No tools were offered, so any tool calls would be left without results.
+	msg.ToolCalls = nil
+
+	if err := a.Session.AddMessage(msg); err != nil {
+		return err
+	}
+	if err := a.recordUsage(resp.Model, resp.Usage, latency, PurposeSummary); err != nil {
+		return err
+	}
+	a.printMessage(msg, show)
+	return stop
+}
+
+// updateMeta refreshes the session metadata after a run and, if configured,
+// asks the model for a title.
+func (a *Agent) updateMeta(ctx context.Context) {
+	if err := a.Session.UpdateMeta(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to update session metadata: %v\n", err)
+		return
+	}
+	if ctx.Err() == nil {
+		a.maybeTitle(ctx)
+	}
+}
+
+// addToolResult records the result of a tool call. exitCode is nil for
+// commands that did not run.
+func (a *Agent) addToolResult(toolCallID, result string, exitCode *int) error {
+	a.emit(toolResultEvent(toolCallID, result, exitCode))
+	return a.Session.AddMessage(Message{
+		Role:       RoleTool,
+		Content:    result,
+		ToolCallID: toolCallID,
+	})
+}
+
+// runTool executes command and formats the result for the model, returning
+// the exit code if the command ran. It only returns an error when ctx was
+// cancelled.
+func (a *Agent) runTool(ctx context.Context, command string, timeout time.Duration) (string, *int, error) {
+	res, err := a.executeBash(ctx, command, timeout)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		return fmt.Sprintf("Error executing bash: %v", err), nil, nil
+	}
+
+	stdout, err := a.handleOutput(res.Stdout, a.Config.Settings.MaxStdout, "stdout")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error handling stdout: %v\n", err)
+		stdout = res.Stdout
+	}
+
+	stderr, err := a.handleOutput(res.Stderr, a.Config.Settings.MaxStderr, "stderr")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error handling stderr: %v\n", err)
+		stderr = res.Stderr
+	}
+
+	return fmt.Sprintf("Exit Code: %d\nSTDOUT:\n%s\nSTDERR:\n%s",
+		res.ExitCode, stdout, stderr), &res.ExitCode, nil
+}
+
+func (a *Agent) executeBash(ctx context.Context, command string, timeout time.Duration) (BashResult, error) {
+	if !a.Config.Settings.PersistentShell {
+		return ExecuteBash(ctx, a.runner, command, a.Config.ProjectRoot, timeout)
+	}
+	if a.shell == nil {
+		a.shell = NewPersistentShell(a.runner, a.Config.ProjectRoot)
+	}
+	return a.shell.Run(ctx, command, timeout)
+}
+
+// Close releases resources held by the agent, such as the persistent shell.
+func (a *Agent) Close() error {
+	if a.shell == nil {
+		return nil
+	}
+	return a.shell.Close()
 }
 
 func (a *Agent) handleOutput(content string, limit int, streamName string) (string, error) {
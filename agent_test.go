@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestRunCancelled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[
+			{"id":"1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"sleep 10\"}"}},
+			{"id":"2","type":"function","function":{"name":"bash","arguments":"{\"command\":\"echo never\"}"}}
+		]}}]}`)
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "saa-test-cancel")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		ProjectRoot: tmpDir,
+		SaaDir:      filepath.Join(tmpDir, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test"},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	start := time.Now()
+	err = agent.Run(ctx, "sleep")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("expected the command to be killed, took %v", time.Since(start))
+	}
+
+	// Both tool calls get a result so the session can be continued.
+	reloaded := NewSession(config)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	msgs := reloaded.Messages
+	if len(msgs) != 5 {
+		t.Fatalf("expected 5 messages, got %d", len(msgs))
+	}
+	for i, id := range []string{"1", "2"} {
+		m := msgs[3+i]
+		if m.Role != RoleTool || m.ToolCallID != id || m.Content != cancelledResult {
+			t.Errorf("unexpected tool result %+v", m)
+		}
+	}
+}
+
+func TestRunBudget(t *testing.T) {
+	var requests []openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		requests = append(requests, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(req.Tools) == 0 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"I echoed twice."}}]}`)
+			return
+		}
+		fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[
+			{"id":"%d","type":"function","function":{"name":"bash","arguments":"{\"command\":\"echo hi\"}"}}
+		]}}],"usage":{"total_tokens":10}}`, len(requests))
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "saa-test-budget")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		ProjectRoot: tmpDir,
+		SaaDir:      filepath.Join(tmpDir, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test", MaxTurns: 2},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+
+	err = agent.Run(context.Background(), "loop")
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Reason != StopMaxTurns {
+		t.Fatalf("expected a turn budget error, got %v", err)
+	}
+	if len(requests) != 3 {
+		t.Fatalf("expected 2 turns and a final request, got %d requests", len(requests))
+	}
+	if len(requests[2].Tools) != 0 {
+		t.Errorf("expected the final request to have no tools")
+	}
+
+	msgs := session.Messages
+	if last := msgs[len(msgs)-1]; last.Content != "I echoed twice." {
+		t.Errorf("expected the summary last, got %+v", last)
+	}
+
+	raw, err := os.ReadFile(session.LogFile)
+	if err != nil {
+		t.Fatalf("failed to read session file: %v", err)
+	}
+	if !strings.Contains(string(raw), `{"saa":"stop"`) || !strings.Contains(string(raw), `"reason":"max_turns"`) {
+		t.Errorf("expected a stop event in the session file")
+	}
+
+	stats, err := ReadSessionStats(session.LogFile)
+	if err != nil || len(stats.Events) != 3 {
+		t.Fatalf("expected 3 usage events, got %+v (%v)", stats, err)
+	}
+	if purpose := stats.Events[2].Purpose; purpose != PurposeSummary {
+		t.Errorf("expected the final request to be recorded as %q, got %q", PurposeSummary, purpose)
+	}
+}
+
+func TestRunBudgetCountsCompaction(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests++
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(req.Tools) == 0 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"summary"}}],"usage":{"total_tokens":10}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[
+			{"id":"%d","type":"function","function":{"name":"bash","arguments":"{\"command\":\"echo hi\"}"}}
+		]}}],"usage":{"total_tokens":10}}`, requests)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	config := &Config{
+		ProjectRoot: tmpDir,
+		SaaDir:      filepath.Join(tmpDir, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test", MaxTotalTokens: 25, ContextBudget: 1, CompactKeep: 1},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+
+	// Two turns and the compaction between them use 30 tokens.
+	err := agent.Run(context.Background(), "loop")
+	var budgetErr *BudgetError
+	if !errors.As(err, &budgetErr) || budgetErr.Reason != StopMaxTotalTokens {
+		t.Fatalf("expected a token budget error, got %v", err)
+	}
+	if requests != 4 {
+		t.Errorf("expected 2 turns, a compaction and a final request, got %d requests", requests)
+	}
+}
@@ -3,7 +3,6 @@ package main
 import (
 	"bytes"
 	"context"
-	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -17,7 +16,15 @@ type BashResult struct {
 	ExitCode int
 }
 
-func ExecuteBash(command string, workDir string, timeout time.Duration) (BashResult, error) {
+// ExecuteBash runs command in a fresh bash process started through runner.
+// A nil runner runs bash directly. Cancelling ctx, or reaching its deadline,
+// kills the whole process group and returns ctx.Err(); only timeout is
+// reported as a timed out command.
+func ExecuteBash(ctx context.Context, runner Runner, command string, workDir string, timeout time.Duration) (BashResult, error) {
+	if runner == nil {
+		runner = PlainRunner{}
+	}
+
 	absWorkDir, err := filepath.Abs(workDir)
 	if err != nil {
 		return BashResult{}, err
@@ -39,14 +46,19 @@ func ExecuteBash(command string, workDir string, timeout time.Duration) (BashRes
 		return BashResult{}, err
 	}
 
-	ctx := context.Background()
+	parent := ctx
 	if timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
 
-	cmd := exec.CommandContext(ctx, "/bin/bash", tmpFile.Name())
+	args, err := runner.Wrap([]string{"/bin/bash", tmpFile.Name()}, absWorkDir)
+	if err != nil {
+		return BashResult{}, err
+	}
+
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Dir = absWorkDir
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Cancel = func() error {
@@ -60,11 +72,11 @@ func ExecuteBash(command string, workDir string, timeout time.Duration) (BashRes
 	err = cmd.Run()
 	exitCode := 0
 	if err != nil {
+		if parent.Err() != nil {
+			return BashResult{}, parent.Err()
+		}
 		if ctx.Err() == context.DeadlineExceeded {
-			return BashResult{
-				Stderr:   fmt.Sprintf("Error: Command timed out after %v.", timeout),
-				ExitCode: -1,
-			}, nil
+			return timeoutResult(timeout), nil
 		}
 
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -82,4 +94,4 @@ func ExecuteBash(command string, workDir string, timeout time.Duration) (BashRes
 		Stderr:   stderrStr,
 		ExitCode: exitCode,
 	}, nil
-}
\ No newline at end of file
+}
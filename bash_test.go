@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 	"testing"
 	"time"
@@ -34,10 +35,10 @@ func TestExecuteBash(t *testing.T) {
 			expectedExit: 1,
 		},
 		{
-			name:        "Timeout",
-			command:     "sleep 2",
-			timeout:     100 * time.Millisecond,
-			expectError: false, // The function handles timeout and returns a result with error message in Stderr
+			name:           "Timeout",
+			command:        "sleep 2",
+			timeout:        100 * time.Millisecond,
+			expectError:    false, // The function handles timeout and returns a result with error message in Stderr
 			expectedStderr: "Error: Command timed out",
 			expectedExit:   -1,
 		},
@@ -51,7 +52,7 @@ func TestExecuteBash(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := ExecuteBash(tt.command, ".", tt.timeout)
+			result, err := ExecuteBash(context.Background(), PlainRunner{}, tt.command, ".", tt.timeout)
 
 			if tt.expectError {
 				if err == nil {
@@ -76,4 +77,18 @@ func TestExecuteBash(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestExecuteBashDeadline(t *testing.T) {
+	// The deadline of ctx, such as the time budget, is not the command's
+	// own timeout.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := ExecuteBash(ctx, PlainRunner{}, "sleep 2", ".", 10*time.Second)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if result := interruptedResult(context.Background()); result != timeBudgetResult {
+		t.Errorf("expected the time budget result, got %q", result)
+	}
+}
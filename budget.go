@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ExitBudget is the exit code when a task was stopped by a budget.
+const ExitBudget = 3
+
+// Reasons recorded in a stop event when a budget runs out.
+const (
+	StopMaxTurns       = "max_turns"
+	StopMaxTotalTokens = "max_total_tokens"
+	StopMaxTime        = "max_time"
+)
+
+// budgetPrompt asks the model for a final answer once a budget ran out.
+const budgetPrompt = `You have reached the %s for this task and cannot run any more commands.
+Summarize what you have done so far, the current state, and what remains to be done.`
+
+// BudgetError is returned by Agent.Run when a budget stopped the task.
+type BudgetError struct {
+	Reason string
+	Limit  string
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("stopped: reached the %s", e.describe())
+}
+
+func (e *BudgetError) describe() string {
+	switch e.Reason {
+	case StopMaxTurns:
+		return fmt.Sprintf("limit of %s tool-call turns", e.Limit)
+	case StopMaxTotalTokens:
+		return fmt.Sprintf("limit of %s tokens", e.Limit)
+	case StopMaxTime:
+		return fmt.Sprintf("time limit of %s", e.Limit)
+	default:
+		return e.Reason
+	}
+}
+
+// budget tracks the limits of a single Agent.Run call. Zero values mean no
+// limit.
+type budget struct {
+	maxTurns  int
+	maxTokens int
+	maxTime   time.Duration
+	deadline  time.Time
+
+	turns  int
+	tokens int
+}
+
+func newBudget(s Settings) (*budget, error) {
+	b := &budget{maxTurns: s.MaxTurns, maxTokens: s.MaxTotalTokens}
+	if s.MaxTime != "" {
+		d, err := time.ParseDuration(s.MaxTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_time: %w", err)
+		}
+		if d > 0 {
+			b.maxTime = d
+			b.deadline = time.Now().Add(d)
+		}
+	}
+	return b, nil
+}
+
+// exceeded returns the budget that ran out, if any.
+func (b *budget) exceeded(now time.Time) *BudgetError {
+	switch {
+	case b.maxTurns > 0 && b.turns >= b.maxTurns:
+		return &BudgetError{Reason: StopMaxTurns, Limit: strconv.Itoa(b.maxTurns)}
+	case b.maxTokens > 0 && b.tokens >= b.maxTokens:
+		return &BudgetError{Reason: StopMaxTotalTokens, Limit: strconv.Itoa(b.maxTokens)}
+	case !b.deadline.IsZero() && !now.Before(b.deadline):
+		return &BudgetError{Reason: StopMaxTime, Limit: b.maxTime.String()}
+	}
+	return nil
+}
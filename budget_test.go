@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudgetExceeded(t *testing.T) {
+	b, err := newBudget(Settings{MaxTurns: 2, MaxTotalTokens: 100, MaxTime: "1m"})
+	if err != nil {
+		t.Fatalf("newBudget failed: %v", err)
+	}
+	now := time.Now()
+	if stop := b.exceeded(now); stop != nil {
+		t.Fatalf("unexpected stop %v", stop)
+	}
+
+	b.tokens = 100
+	if stop := b.exceeded(now); stop == nil || stop.Reason != StopMaxTotalTokens {
+		t.Errorf("expected token budget to stop, got %v", stop)
+	}
+
+	b.tokens = 0
+	b.turns = 2
+	if stop := b.exceeded(now); stop == nil || stop.Reason != StopMaxTurns || stop.Limit != "2" {
+		t.Errorf("expected turn budget to stop, got %v", stop)
+	}
+
+	b.turns = 0
+	if stop := b.exceeded(now.Add(2 * time.Minute)); stop == nil || stop.Reason != StopMaxTime {
+		t.Errorf("expected time budget to stop, got %v", stop)
+	}
+
+	if _, err := newBudget(Settings{MaxTime: "soon"}); err == nil {
+		t.Errorf("expected an error for an invalid max_time")
+	}
+	unlimited, _ := newBudget(Settings{})
+	unlimited.turns, unlimited.tokens = 1000, 1000000
+	if stop := unlimited.exceeded(now.Add(24 * time.Hour)); stop != nil {
+		t.Errorf("expected no limits by default, got %v", stop)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Kinds of problems CheckSession finds.
+const (
+	ProblemMalformed    = "malformed line"
+	ProblemDanglingCall = "tool call without result"
+	ProblemOrphanResult = "tool result without call"
+)
+
+// missingResult is recorded for tool calls whose result was never written,
+// usually because saa was killed while the command ran.
+const missingResult = "Command result missing: the session was interrupted."
+
+// SessionProblem is a line of a session file the API would reject or saa
+// cannot read. Line numbers start at 1.
+type SessionProblem struct {
+	Line   int
+	Kind   string
+	Detail string
+}
+
+func (p SessionProblem) String() string {
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Kind, p.Detail)
+}
+
+// backupPath returns the copy of a session file kept by RepairSession.
+func backupPath(logFile string) string {
+	return strings.TrimSuffix(logFile, ".jsonl") + ".bak"
+}
+
+// checkLines finds the problems in the lines of a session file and returns
+// the lines with them fixed: malformed lines and orphaned results are
+// dropped, and dangling tool calls get a result saying it is missing.
+func checkLines(lines [][]byte) ([]SessionProblem, [][]byte) {
+	var problems []SessionProblem
+	var out [][]byte
+
+	// pending holds the calls of the last assistant message that have no
+	// result yet, in the order they were made.
+	var pending []ToolCall
+	callLine := 0
+	closePending := func() {
+		for _, tc := range pending {
+			problems = append(problems, SessionProblem{Line: callLine, Kind: ProblemDanglingCall, Detail: fmt.Sprintf("%s (%s)", tc.ID, toolCallCommand(tc))})
+			data, _ := json.Marshal(Message{
+				Role:       RoleTool,
+				Content:    missingResult,
+				ToolCallID: tc.ID,
+			})
+			out = append(out, data)
+		}
+		pending = nil
+	}
+
+	for i, line := range lines {
+		n := i + 1
+		var probe struct {
+			Type string `json:"saa"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			problems = append(problems, SessionProblem{Line: n, Kind: ProblemMalformed, Detail: truncateText(string(line), 60)})
+			continue
+		}
+		if probe.Type != "" {
+			out = append(out, line)
+			continue
+		}
+		msg, err := decodeMessage(line)
+		if err != nil || msg.Role == "" {
+			problems = append(problems, SessionProblem{Line: n, Kind: ProblemMalformed, Detail: truncateText(string(line), 60)})
+			continue
+		}
+
+		if msg.Role == RoleTool {
+			j := slices.IndexFunc(pending, func(tc ToolCall) bool { return tc.ID == msg.ToolCallID })
+			if j < 0 {
+				problems = append(problems, SessionProblem{Line: n, Kind: ProblemOrphanResult, Detail: msg.ToolCallID})
+				continue
+			}
+			pending = slices.Delete(pending, j, j+1)
+			out = append(out, line)
+			continue
+		}
+
+		closePending()
+		out = append(out, line)
+		if len(msg.ToolCalls) > 0 {
+			pending = slices.Clone(msg.ToolCalls)
+			callLine = n
+		}
+	}
+	closePending()
+	return problems, out
+}
+
+// CheckSession reports the problems in a session file.
+func CheckSession(path string) ([]SessionProblem, error) {
+	lines, err := readSessionLines(path)
+	if err != nil {
+		return nil, err
+	}
+	problems, _ := checkLines(lines)
+	return problems, nil
+}
+
+// RepairSession fixes the problems in a session file, keeping the original
+// in a .bak file next to it. It returns the problems it fixed.
+func RepairSession(path string) ([]SessionProblem, error) {
+	lock, err := tryLock(path)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Unlock()
+
+	lines, err := readSessionLines(path)
+	if err != nil {
+		return nil, err
+	}
+	problems, fixed := checkLines(lines)
+	if len(problems) == 0 {
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(backupPath(path), data, 0644); err != nil {
+		return nil, err
+	}
+	return problems, writeSessionLines(path, fixed)
+}
+
+func printProblems(w io.Writer, problems []SessionProblem) {
+	for _, p := range problems {
+		fmt.Fprintln(w, p)
+	}
+}
+
+// danglingToolCalls returns the IDs of the tool calls in msgs that have no
+// result.
+func danglingToolCalls(msgs []Message) []string {
+	var ids []string
+	for _, m := range msgs {
+		for _, tc := range m.ToolCalls {
+			ids = append(ids, tc.ID)
+		}
+		if m.Role == RoleTool {
+			ids = slices.DeleteFunc(ids, func(id string) bool { return id == m.ToolCallID })
+		}
+	}
+	return ids
+}
+
+// closeToolCalls records a missing result for the tool calls at the end of
+// the session that have none, so the next request is accepted. Calls
+// without results earlier in the session need `saa session repair`.
+func (s *Session) closeToolCalls() error {
+	ids := danglingToolCalls(s.Messages)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	last := len(s.Messages) - 1
+	for last > 0 && s.Messages[last].Role == RoleTool {
+		last--
+	}
+	for _, id := range ids {
+		if !slices.ContainsFunc(s.Messages[last].ToolCalls, func(tc ToolCall) bool { return tc.ID == id }) {
+			return fmt.Errorf("%s has tool calls without results; run `saa session repair` to fix it", filepath.Base(s.LogFile))
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Recording %d tool calls of an interrupted run as missing.\n", len(ids))
+	for _, id := range ids {
+		if err := s.AddMessage(Message{
+			Role:       RoleTool,
+			Content:    missingResult,
+			ToolCallID: id,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
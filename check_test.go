@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func writeTestLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+}
+
+const (
+	testSystemLine = `{"role":"system","content":"system"}`
+	testUserLine   = `{"role":"user","content":"hi"}`
+	testCallLine   = `{"role":"assistant","tool_calls":[{"id":"a","type":"function","function":{"name":"bash","arguments":"{\"command\":\"ls\"}"}},{"id":"b","type":"function","function":{"name":"bash","arguments":"{\"command\":\"pwd\"}"}}]}`
+	testResultLine = `{"role":"tool","content":"Exit Code: 0","tool_call_id":"a"}`
+)
+
+func TestCheckSession(t *testing.T) {
+	session := newTestSession(t)
+	writeTestLines(t, session.LogFile,
+		testSystemLine,
+		testUserLine,
+		testCallLine,
+		testResultLine,
+		`{"role":"tool","content":"stray","tool_call_id":"zzz"}`,
+		testUserLine,
+		`{"role":"assistant","content":"trunc`,
+	)
+
+	problems, err := CheckSession(session.LogFile)
+	if err != nil {
+		t.Fatalf("CheckSession failed: %v", err)
+	}
+	want := []SessionProblem{
+		{Line: 5, Kind: ProblemOrphanResult},
+		{Line: 3, Kind: ProblemDanglingCall},
+		{Line: 7, Kind: ProblemMalformed},
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("problems = %v", problems)
+	}
+	for i, p := range problems {
+		if p.Line != want[i].Line || p.Kind != want[i].Kind {
+			t.Errorf("problem %d = %v, want line %d: %s", i, p, want[i].Line, want[i].Kind)
+		}
+	}
+
+	fixed, err := RepairSession(session.LogFile)
+	if err != nil || len(fixed) != 3 {
+		t.Fatalf("RepairSession = %v, %v", fixed, err)
+	}
+	if _, err := os.Stat(backupPath(session.LogFile)); err != nil {
+		t.Errorf("no backup written: %v", err)
+	}
+	if problems, _ := CheckSession(session.LogFile); len(problems) != 0 {
+		t.Errorf("problems left after repair: %v", problems)
+	}
+
+	if err := session.loadMessages(); err != nil {
+		t.Fatalf("loadMessages failed: %v", err)
+	}
+	if len(session.Messages) != 6 {
+		t.Fatalf("expected 6 messages, got %d", len(session.Messages))
+	}
+	if m := session.Messages[4]; m.ToolCallID != "b" || m.Content != missingResult {
+		t.Errorf("unexpected result for the dangling call: %+v", m)
+	}
+}
+
+func TestLoadLongLine(t *testing.T) {
+	session := newTestSession(t)
+	long := strings.Repeat("x", 200*1024)
+	if err := session.AddMessage(Message{Role: RoleTool, Content: long}); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	session.AddMessage(Message{Role: RoleUser, Content: "after"})
+
+	if err := session.loadMessages(); err != nil {
+		t.Fatalf("loadMessages failed: %v", err)
+	}
+	if len(session.Messages) != 3 || session.Messages[1].Content != long || session.Messages[2].Content != "after" {
+		t.Errorf("long line not loaded: %d messages", len(session.Messages))
+	}
+}
+
+func TestCloseToolCalls(t *testing.T) {
+	session := newTestSession(t)
+	writeTestLines(t, session.LogFile, testSystemLine, testUserLine, testCallLine, testResultLine)
+	if err := session.loadMessages(); err != nil {
+		t.Fatalf("loadMessages failed: %v", err)
+	}
+
+	if err := session.closeToolCalls(); err != nil {
+		t.Fatalf("closeToolCalls failed: %v", err)
+	}
+	if ids := danglingToolCalls(session.Messages); len(ids) != 0 {
+		t.Errorf("calls still without result: %v", ids)
+	}
+	lines, _ := readSessionLines(session.LogFile)
+	var last Message
+	json.Unmarshal(lines[len(lines)-1], &last)
+	if last.ToolCallID != "b" || last.Content != missingResult {
+		t.Errorf("missing result not recorded: %+v", last)
+	}
+
+	writeTestLines(t, session.LogFile, testSystemLine, testUserLine, testCallLine, testUserLine)
+	session.loadMessages()
+	if err := session.closeToolCalls(); err == nil {
+		t.Errorf("closeToolCalls fixed calls in the middle of the session")
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+func NewChatCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "chat",
+		Short: "Start an interactive session",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runChat(cmd)
+		},
+	}
+}
+
+func runChat(cmd *cobra.Command) error {
+	config, err := NewConfig()
+	if err != nil {
+		return err
+	}
+	applyDisplayFlags(cmd, config)
+
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	session := NewSession(config)
+	if err := session.Load(); err != nil {
+		return err
+	}
+
+	agent := NewAgent(config, session)
+	defer agent.Close()
+
+	editor := NewLineEditor(filepath.Join(config.SaaDir, "history"))
+	agent.stdin = editor.in
+
+	r := &repl{ctx: cmd.Context(), config: config, session: session, agent: agent, editor: editor}
+	return r.loop()
+}
+
+// repl keeps one agent and session in memory across prompts.
+type repl struct {
+	ctx     context.Context
+	config  *Config
+	session *Session
+	agent   *Agent
+	editor  *LineEditor
+}
+
+const chatHelp = `Commands:
+  /new               Start a new session
+  /switch <session>  Switch to a session by file, title, tag or id
+  /sessions          List sessions
+  /stdout <id>       Show a truncated stdout log
+  /stderr <id>       Show a truncated stderr log
+  /verbose [on|off]  Toggle showing tool calls, results and reasoning
+  /help              Show this help
+  /exit              Leave (or press Ctrl-D)
+
+End a line with \ to continue it, or enclose several lines in """.
+Ctrl-C cancels the running request or command.
+`
+
+func (r *repl) loop() error {
+	// Ctrl-C cancels the current turn instead of terminating saa, so take
+	// SIGINT over from the handler installed by main.
+	signal.Reset(os.Interrupt)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+
+	fmt.Println("Type /help for commands, Ctrl-D to exit.")
+	for {
+		if r.ctx.Err() != nil {
+			return r.ctx.Err()
+		}
+
+		input, err := r.readInput()
+		if errors.Is(err, errInterrupted) {
+			continue
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		input = strings.TrimSpace(input)
+		if input == "" {
+			continue
+		}
+
+		if strings.HasPrefix(input, "/") {
+			quit, err := r.command(input)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+			if quit {
+				return nil
+			}
+			continue
+		}
+
+		if err := r.run(input, sigs); err != nil {
+			if errors.Is(err, context.Canceled) {
+				fmt.Fprintln(os.Stderr, "\nCancelled.")
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		}
+	}
+}
+
+func (r *repl) run(prompt string, sigs <-chan os.Signal) error {
+	// Drop interrupts that arrived while no turn was running.
+	select {
+	case <-sigs:
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(r.ctx)
+	defer cancel()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case <-sigs:
+			os.Exit(ExitCancelled)
+		case <-done:
+		}
+	}()
+
+	err := r.agent.Run(ctx, prompt)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// readInput reads one prompt, joining lines that end with a backslash and
+// lines enclosed in """.
+func (r *repl) readInput() (string, error) {
+	line, err := r.editor.ReadLine("saa> ")
+	if err != nil {
+		return "", err
+	}
+	r.editor.AddHistory(line)
+
+	if strings.TrimSpace(line) == `"""` {
+		var lines []string
+		for {
+			line, err := r.editor.ReadLine("... ")
+			if err != nil {
+				return "", err
+			}
+			r.editor.AddHistory(line)
+			if strings.TrimSpace(line) == `"""` {
+				return strings.Join(lines, "\n"), nil
+			}
+			lines = append(lines, line)
+		}
+	}
+
+	var lines []string
+	for strings.HasSuffix(line, `\`) {
+		lines = append(lines, strings.TrimSuffix(line, `\`))
+		line, err = r.editor.ReadLine("... ")
+		if err != nil {
+			return "", err
+		}
+		r.editor.AddHistory(line)
+	}
+	return strings.Join(append(lines, line), "\n"), nil
+}
+
+// command runs a slash command and reports whether the REPL should exit.
+func (r *repl) command(input string) (bool, error) {
+	fields := strings.Fields(input)
+	name, args := fields[0], fields[1:]
+
+	switch name {
+	case "/exit", "/quit":
+		return true, nil
+	case "/help":
+		fmt.Print(chatHelp)
+	case "/new":
+		// Like /switch, a pinned session only moves the pin.
+		if r.config.Settings.Session != "" {
+			if err := r.session.CreateSession(); err != nil {
+				return false, err
+			}
+			r.config.Settings.Session = filepath.Base(r.session.LogFile)
+		} else if err := r.session.NewSession(); err != nil {
+			return false, err
+		}
+		r.session.autoPrune(os.Stderr)
+		fmt.Printf("Started session: %s\n", filepath.Base(r.session.LogFile))
+	case "/switch":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: /switch <session>")
+		}
+		filename, err := r.session.Resolve(args[0])
+		if err != nil {
+			return false, err
+		}
+		// A pinned session stays pinned to the new choice rather than
+		// moving the current pointer of other processes.
+		if r.config.Settings.Session != "" {
+			r.config.Settings.Session = filename
+		} else if err := r.session.Switch(filename); err != nil {
+			return false, err
+		}
+		if err := r.session.Load(); err != nil {
+			return false, err
+		}
+		fmt.Printf("Switched to session: %s\n", filename)
+	case "/sessions":
+		files, err := r.session.List()
+		if err != nil {
+			return false, err
+		}
+		current := filepath.Base(r.session.LogFile)
+		for _, f := range files {
+			marker := " "
+			if f == current {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, f)
+		}
+	case "/stdout", "/stderr":
+		if len(args) != 1 {
+			return false, fmt.Errorf("usage: %s <timestamp-uuid>", name)
+		}
+		return false, showLog(args[0], strings.TrimPrefix(name, "/"))
+	case "/verbose":
+		switch len(args) {
+		case 0:
+			r.config.Settings.Verbose = !r.config.Settings.Verbose
+		case 1:
+			v, err := parseBool(args[0])
+			if err != nil {
+				return false, err
+			}
+			r.config.Settings.Verbose = v
+		default:
+			return false, fmt.Errorf("usage: /verbose [on|off]")
+		}
+		fmt.Printf("Verbose: %v\n", r.config.Settings.Verbose)
+	default:
+		return false, fmt.Errorf("unknown command: %s (try /help)", name)
+	}
+	return false, nil
+}
@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -14,6 +15,18 @@ var (
 	maxStdout        int
 	maxStderr        int
 	systemPromptFile string
+	stream           bool
+	persistentShell  bool
+	maxTurns         int
+	maxTotalTokens   int
+	maxTime          time.Duration
+	showUsage        bool
+	sessionWait      bool
+	snapshots        bool
+	outputFormat     string
+	textTools        bool
+	requestParams    []string
+	parallelTools    int
 )
 
 func NewExecCmd() *cobra.Command {
@@ -27,17 +40,18 @@ func NewExecCmd() *cobra.Command {
 				return err
 			}
 
-			if cmd.Flags().Changed("show-tool-call") {
-				config.Settings.ShowToolCall = showToolCall
-			}
-			if cmd.Flags().Changed("show-tool-result") {
-				config.Settings.ShowToolResult = showToolResult
-			}
-			if cmd.Flags().Changed("show-reasoning") {
-				config.Settings.ShowReasoning = showReasoning
+			applyDisplayFlags(cmd, config)
+
+			for _, p := range requestParams {
+				if err := config.Settings.Request.Set(p); err != nil {
+					return err
+				}
 			}
-			if cmd.Flags().Changed("verbose") {
-				config.Settings.Verbose = verbose
+
+			switch outputFormat {
+			case "text", "jsonl":
+			default:
+				return fmt.Errorf("invalid output format: %s (use text or jsonl)", outputFormat)
 			}
 
 			if err := config.Validate(); err != nil {
@@ -67,7 +81,26 @@ func NewExecCmd() *cobra.Command {
 			}
 
 			agent := NewAgent(config, session)
-			return agent.Run(prompt)
+			defer agent.Close()
+
+			var events *jsonlWriter
+			if outputFormat == "jsonl" {
+				events = newJSONLWriter(os.Stdout)
+				agent.Events = events.write
+			}
+
+			err = agent.Run(cmd.Context(), prompt)
+			if config.Settings.ShowUsage {
+				fmt.Fprintln(os.Stderr, agent.Usage())
+			}
+			if events != nil {
+				finished := finishEvent(err)
+				if finished.Reason == FinishError {
+					events.write(RunEvent{Type: RunError, Error: finished.Error})
+				}
+				events.write(finished)
+			}
+			return err
 		},
 	}
 
@@ -75,10 +108,36 @@ func NewExecCmd() *cobra.Command {
 	cmd.Flags().IntVar(&maxStdout, "max-stdout", DefaultMaxOutput, "Maximum characters for stdout before truncation. Use -1 for no limit.")
 	cmd.Flags().IntVar(&maxStderr, "max-stderr", DefaultMaxOutput, "Maximum characters for stderr before truncation. Use -1 for no limit.")
 	cmd.Flags().StringVar(&systemPromptFile, "system-prompt", "", "File containing the system prompt")
+	cmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format: text, or jsonl for one JSON event per line")
+	cmd.Flags().Var(&toggleBool{&stream}, "stream", "Stream model responses as they are generated")
+	cmd.Flags().Var(&toggleBool{&persistentShell}, "persistent-shell", "Run all commands of this task in one long-lived bash process")
+	cmd.Flags().BoolVar(&textTools, "text-tools", false, "Let the model run commands by writing <bash> tags, for models without tool calling")
+	cmd.Flags().StringArrayVar(&requestParams, "param", nil, "Set a request parameter as key=value, e.g. temperature=0 or options.num_ctx=8192 (repeatable)")
+	cmd.Flags().IntVar(&parallelTools, "parallel-tools", 0, "Run up to this many read-only commands of one reply at once (0 runs them one by one)")
+	cmd.Flags().BoolVar(&snapshots, "snapshots", false, "Record the project files around each command for saa diff and saa undo")
+
+	cmd.Flags().IntVar(&maxTurns, "max-turns", 0, "Stop after this many tool-call turns (0 for no limit)")
+	cmd.Flags().IntVar(&maxTotalTokens, "max-total-tokens", 0, "Stop after using this many prompt and completion tokens (0 for no limit)")
+	cmd.Flags().BoolVar(&showUsage, "usage", false, "Print token usage and estimated cost when the task ends")
+	cmd.Flags().BoolVar(&sessionWait, "wait", false, "Wait for the session if another saa process is using it")
+	cmd.Flags().DurationVar(&maxTime, "max-time", 0, "Stop after this much wall-clock time, e.g. 10m (0 for no limit)")
+
+	cmd.Flags().Lookup("stream").NoOptDefVal = "true"
+	cmd.Flags().Lookup("persistent-shell").NoOptDefVal = "true"
 
 	viper.BindPFlag("max_stdout", cmd.Flags().Lookup("max-stdout"))
 	viper.BindPFlag("max_stderr", cmd.Flags().Lookup("max-stderr"))
 	viper.BindPFlag("system_prompt_file", cmd.Flags().Lookup("system-prompt"))
+	viper.BindPFlag("stream", cmd.Flags().Lookup("stream"))
+	viper.BindPFlag("persistent_shell", cmd.Flags().Lookup("persistent-shell"))
+	viper.BindPFlag("snapshots", cmd.Flags().Lookup("snapshots"))
+	viper.BindPFlag("text_tools", cmd.Flags().Lookup("text-tools"))
+	viper.BindPFlag("parallel_tools", cmd.Flags().Lookup("parallel-tools"))
+	viper.BindPFlag("max_turns", cmd.Flags().Lookup("max-turns"))
+	viper.BindPFlag("max_total_tokens", cmd.Flags().Lookup("max-total-tokens"))
+	viper.BindPFlag("max_time", cmd.Flags().Lookup("max-time"))
+	viper.BindPFlag("show_usage", cmd.Flags().Lookup("usage"))
+	viper.BindPFlag("session_wait", cmd.Flags().Lookup("wait"))
 
 	return cmd
 }
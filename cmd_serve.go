@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	listenAddr string
+	serveToken string
+)
+
+func NewServeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve sessions and the agent over HTTP",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+			if err := config.Validate(); err != nil {
+				return err
+			}
+
+			ln, err := net.Listen("tcp", listenAddr)
+			if err != nil {
+				return err
+			}
+
+			// Any local process or web page could otherwise run commands.
+			token := config.Settings.ServeToken
+			if token == "" {
+				b := make([]byte, 16)
+				rand.Read(b)
+				token = hex.EncodeToString(b)
+				fmt.Fprintf(os.Stderr, "No serve_token set; this run requires the token %s\n", token)
+			}
+
+			server := NewServer(config, token, ln.Addr().String())
+			defer server.Close()
+			httpServer := &http.Server{Handler: server.Handler()}
+
+			// The first signal stops accepting requests and cancels running
+			// turns.
+			go func() {
+				<-cmd.Context().Done()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				server.CancelAll()
+				httpServer.Shutdown(ctx)
+			}()
+
+			fmt.Fprintf(os.Stderr, "Listening on http://%s\n", ln.Addr())
+			if err := httpServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listenAddr, "listen", "127.0.0.1:8080", "Address to listen on")
+	cmd.Flags().StringVar(&serveToken, "token", "", "Require this bearer token on every request")
+
+	viper.BindPFlag("serve_token", cmd.Flags().Lookup("token"))
+
+	return cmd
+}
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
@@ -26,6 +31,7 @@ func NewSessionCmd() *cobra.Command {
 		},
 	}
 
+	var long, asJSON bool
 	listCmd := &cobra.Command{
 		Use:   "list",
 		Short: "List all session history files",
@@ -41,12 +47,31 @@ func NewSessionCmd() *cobra.Command {
 				return err
 			}
 
+			if !long && !asJSON {
+				for _, f := range files {
+					fmt.Println(f)
+				}
+				return nil
+			}
+
+			current, _ := session.GetCurrentLogFile()
+			entries := make([]sessionEntry, 0, len(files))
 			for _, f := range files {
-				fmt.Println(f)
+				meta, err := ReadMeta(filepath.Join(session.SessionDir, f))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "%s: %v\n", f, err)
+				}
+				entries = append(entries, sessionEntry{File: f, Current: f == current, SessionMeta: meta})
 			}
+			if asJSON {
+				return printSessionsJSON(os.Stdout, entries)
+			}
+			printSessionsLong(os.Stdout, entries)
 			return nil
 		},
 	}
+	listCmd.Flags().BoolVarP(&long, "long", "l", false, "Show titles, tags, times, models and message counts")
+	listCmd.Flags().BoolVar(&asJSON, "json", false, "Print the sessions and their metadata as JSON")
 
 	currentCmd := &cobra.Command{
 		Use:   "current",
@@ -87,8 +112,8 @@ func NewSessionCmd() *cobra.Command {
 	}
 
 	switchCmd := &cobra.Command{
-		Use:   "switch [session-file]",
-		Short: "Switch to a specific session history file",
+		Use:   "switch [session]",
+		Short: "Switch to a session by file name, title, tag or id prefix",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			config, err := NewConfig()
@@ -97,14 +122,105 @@ func NewSessionCmd() *cobra.Command {
 			}
 
 			session := NewSession(config)
-			if err := session.Switch(args[0]); err != nil {
+			filename, err := session.Resolve(args[0])
+			if err != nil {
+				return err
+			}
+			if err := session.Switch(filename); err != nil {
+				return err
+			}
+
+			fmt.Printf("Switched to session: %s\n", filename)
+			return nil
+		},
+	}
+
+	var (
+		dryRun    bool
+		keepLast  int
+		olderThan string
+		maxSize   string
+	)
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove old sessions and orphaned output logs",
+		Long: `Remove the sessions selected by the retention rules in the prune settings
+(or the flags), along with their output logs, and any output log no session
+refers to. The current session is never removed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
 				return err
 			}
 
-			fmt.Printf("Switched to session: %s\n", args[0])
+			settings := config.Settings.Prune
+			if cmd.Flags().Changed("keep") {
+				settings.KeepLast = keepLast
+			}
+			if cmd.Flags().Changed("older-than") {
+				settings.MaxAge = olderThan
+			}
+			if cmd.Flags().Changed("max-size") {
+				settings.MaxSize = maxSize
+			}
+
+			session := NewSession(config)
+			result, err := session.Prune(settings, dryRun)
+			if err != nil {
+				return err
+			}
+			result.Print(os.Stdout, dryRun)
 			return nil
 		},
 	}
+	pruneCmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show what would be removed without removing it")
+	pruneCmd.Flags().IntVar(&keepLast, "keep", 0, "Keep only the N most recently used sessions")
+	pruneCmd.Flags().StringVar(&olderThan, "older-than", "", "Remove sessions not used for this long (e.g. 30d, 12h)")
+	pruneCmd.Flags().StringVar(&maxSize, "max-size", "", "Remove the least recently used sessions until the rest fit (e.g. 500M)")
+
+	renameCmd := &cobra.Command{
+		Use:   "rename <title>",
+		Short: "Set the title of the current session",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveTarget("")
+			if err != nil {
+				return err
+			}
+			return SetTitle(path, strings.Join(args, " "))
+		},
+	}
+
+	var removeTags bool
+	tagCmd := &cobra.Command{
+		Use:   "tag <tag>...",
+		Short: "Add tags to the current session",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveTarget("")
+			if err != nil {
+				return err
+			}
+			if removeTags {
+				return SetTags(path, nil, args)
+			}
+			return SetTags(path, args, nil)
+		},
+	}
+	tagCmd.Flags().BoolVarP(&removeTags, "remove", "d", false, "Remove the tags instead of adding them")
 
 	stdoutCmd := &cobra.Command{
 		Use:   "stdout [timestamp-uuid]",
@@ -124,10 +240,461 @@ func NewSessionCmd() *cobra.Command {
 		},
 	}
 
-	cmd.AddCommand(listCmd, currentCmd, clearCmd, switchCmd, stdoutCmd, stderrCmd)
+	var compactKeep int
+	compactCmd := &cobra.Command{
+		Use:   "compact",
+		Short: "Summarize older messages of the current session",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+			if err := config.Validate(); err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			if err := session.Load(); err != nil {
+				return err
+			}
+
+			lock, err := session.Lock(cmd.Context(), false)
+			if err != nil {
+				return err
+			}
+			defer lock.Unlock()
+			// A run holding the lock may have added to the session since
+			// it was loaded.
+			if err := session.loadMessages(); err != nil {
+				return err
+			}
+
+			keep := config.Settings.CompactKeep
+			if cmd.Flags().Changed("keep") {
+				keep = compactKeep
+			}
+
+			agent := NewAgent(config, session)
+			n, err := agent.Compact(cmd.Context(), keep)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				fmt.Println("Nothing to compact.")
+				return nil
+			}
+
+			fmt.Printf("Compacted %d messages into a summary.\n", n)
+			return nil
+		},
+	}
+	compactCmd.Flags().IntVar(&compactKeep, "keep", DefaultCompactKeep, "Number of recent messages to keep as they are")
+
+	statsCmd := &cobra.Command{
+		Use:   "stats [session-file]",
+		Short: "Show token usage and estimated cost of a session",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+
+			stats, err := ReadSessionStats(path)
+			if err != nil {
+				return err
+			}
+			stats.Print(os.Stdout, config.Settings.Prices)
+			return nil
+		},
+	}
+
+	var forkAt int
+	forkCmd := &cobra.Command{
+		Use:   "fork [session-file]",
+		Short: "Copy a session into a new one and switch to it",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+
+			at := -1
+			if cmd.Flags().Changed("at") {
+				if forkAt < 0 {
+					return fmt.Errorf("--at must not be negative")
+				}
+				at = forkAt
+			}
+
+			filename, err := session.Fork(path, at)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Forked %s into %s\n", filepath.Base(path), filename)
+			return nil
+		},
+	}
+	forkCmd.Flags().IntVar(&forkAt, "at", 0, "Number of user turns to copy (default all)")
+
+	var restore bool
+	rewindCmd := &cobra.Command{
+		Use:   "rewind [N]",
+		Short: "Drop the last N user turns from the current session",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			if err := session.Load(); err != nil {
+				return err
+			}
+
+			lock, err := session.Lock(cmd.Context(), false)
+			if err != nil {
+				return err
+			}
+			defer lock.Unlock()
+			// A run holding the lock may have added to the session since
+			// it was loaded.
+			if err := session.loadMessages(); err != nil {
+				return err
+			}
+
+			if restore {
+				if len(args) > 0 {
+					return fmt.Errorf("--restore takes no arguments")
+				}
+				n, err := session.Restore()
+				if err != nil {
+					return err
+				}
+				fmt.Printf("Restored %d turns.\n", n)
+				return nil
+			}
+
+			n := 1
+			if len(args) > 0 {
+				n, err = strconv.Atoi(args[0])
+				if err != nil || n < 1 {
+					return fmt.Errorf("invalid number of turns: %s", args[0])
+				}
+			}
+
+			n, err = session.Rewind(n)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				fmt.Println("Nothing to rewind.")
+				return nil
+			}
+			fmt.Printf("Removed %d turns. Use 'saa session rewind --restore' to bring them back.\n", n)
+			return nil
+		},
+	}
+	rewindCmd.Flags().BoolVar(&restore, "restore", false, "Put back the turns removed by earlier rewinds")
+
+	lineageCmd := &cobra.Command{
+		Use:   "lineage [session-file]",
+		Short: "Show the sessions a session was forked from and into",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+			return session.PrintLineage(os.Stdout, path)
+		},
+	}
+
+	checkCmd := &cobra.Command{
+		Use:   "check [session-file]",
+		Short: "Report malformed lines and unmatched tool calls in a session",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+			problems, err := CheckSession(path)
+			if err != nil {
+				return err
+			}
+			if len(problems) == 0 {
+				fmt.Printf("No problems found in %s.\n", filepath.Base(path))
+				return nil
+			}
+			printProblems(os.Stdout, problems)
+
+			if isTerminal(os.Stdin) && confirm(fmt.Sprintf("Repair %s? [y/N] ", filepath.Base(path))) {
+				return repairSession(path)
+			}
+			return fmt.Errorf("%d problems found; run `saa session repair` to fix them", len(problems))
+		},
+	}
+
+	repairCmd := &cobra.Command{
+		Use:   "repair [session-file]",
+		Short: "Fix the problems reported by check",
+		Long: `Drop malformed lines and tool results without a call, and record a missing
+result for tool calls that have none. The original file is kept as .bak.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+			return repairSession(path)
+		},
+	}
+
+	var color string
+	var noPager, withSystem bool
+	showCmd := &cobra.Command{
+		Use:   "show [session-file]",
+		Short: "Show a session in readable form",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+
+			var useColor bool
+			switch color {
+			case "auto":
+				useColor = isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+			case "always":
+				useColor = true
+			case "never":
+			default:
+				return fmt.Errorf("invalid --color value: %s (use auto, always or never)", color)
+			}
+
+			msgs, err := loadTranscript(path, false)
+			if err != nil {
+				return err
+			}
+
+			write := func(w io.Writer) error {
+				renderText(w, msgs, useColor, withSystem)
+				return nil
+			}
+			if noPager {
+				return write(os.Stdout)
+			}
+			return withPager(write)
+		},
+	}
+	showCmd.Flags().StringVar(&color, "color", "auto", "Color output: auto, always or never")
+	showCmd.Flags().BoolVar(&noPager, "no-pager", false, "Do not page the output")
+	showCmd.Flags().BoolVar(&withSystem, "system", false, "Include the system prompt")
+
+	var format, output string
+	exportCmd := &cobra.Command{
+		Use:   "export [session-file]",
+		Short: "Export a session as Markdown, HTML or JSON",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			session := NewSession(config)
+			path, err := session.resolveFile(args)
+			if err != nil {
+				return err
+			}
+
+			msgs, err := loadTranscript(path, true)
+			if err != nil {
+				return err
+			}
+			title := strings.TrimSuffix(filepath.Base(path), ".jsonl")
+
+			w := io.Writer(os.Stdout)
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				w = f
+			}
+
+			switch format {
+			case "markdown", "md":
+				renderMarkdown(w, title, msgs)
+				return nil
+			case "html":
+				return renderHTML(w, title, msgs)
+			case "json":
+				return renderJSON(w, title, msgs)
+			default:
+				return fmt.Errorf("unknown format: %s (use markdown, html or json)", format)
+			}
+		},
+	}
+	exportCmd.Flags().StringVar(&format, "format", "markdown", "Output format: markdown, html or json")
+	exportCmd.Flags().StringVarP(&output, "output", "o", "", "Write to this file instead of stdout")
+
+	var searchOpts SearchOptions
+	var since, until string
+	var useIndex bool
+	searchCmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search all sessions",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			config, err := NewConfig()
+			if err != nil {
+				return err
+			}
+
+			opts := searchOpts
+			opts.Query = args[0]
+			for _, role := range opts.Roles {
+				switch role {
+				case RoleUser, RoleAssistant, RoleTool, RoleSystem:
+				default:
+					return fmt.Errorf("invalid role: %s (use user, assistant, tool or system)", role)
+				}
+			}
+			now := time.Now()
+			if since != "" {
+				if opts.Since, err = parseDateArg(since, now, false); err != nil {
+					return err
+				}
+			}
+			if until != "" {
+				if opts.Until, err = parseDateArg(until, now, true); err != nil {
+					return err
+				}
+			}
+
+			session := NewSession(config)
+			results, err := session.Search(opts, useIndex || config.Settings.SearchIndex)
+			if err != nil {
+				return err
+			}
+			if len(results) == 0 {
+				return fmt.Errorf("no matches")
+			}
+			printSearchResults(os.Stdout, results, isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "")
+			return nil
+		},
+	}
+	searchCmd.Flags().BoolVarP(&searchOpts.Regex, "regex", "e", false, "Treat the query as a regular expression (case-sensitive unless it starts with (?i))")
+	searchCmd.Flags().StringSliceVar(&searchOpts.Roles, "role", nil, "Only search messages of these roles (user, assistant, tool, system)")
+	searchCmd.Flags().StringVar(&since, "since", "", "Only search sessions created on or after this date (YYYY-MM-DD, or an age like 7d)")
+	searchCmd.Flags().StringVar(&until, "until", "", "Only search sessions created on or before this date")
+	searchCmd.Flags().BoolVar(&searchOpts.Logs, "logs", false, "Also search the full logs of truncated outputs")
+	searchCmd.Flags().IntVarP(&searchOpts.Limit, "limit", "n", 0, "Stop after this many matches")
+	searchCmd.Flags().BoolVar(&useIndex, "index", false, "Cache extracted text in .saa/search-index.json to speed up later searches")
+
+	cmd.AddCommand(listCmd, currentCmd, clearCmd, pruneCmd, switchCmd, renameCmd, tagCmd, stdoutCmd, stderrCmd, compactCmd, statsCmd, forkCmd, rewindCmd, lineageCmd, checkCmd, repairCmd, showCmd, exportCmd, searchCmd)
 	return cmd
 }
 
+func repairSession(path string) error {
+	problems, err := RepairSession(path)
+	if err != nil {
+		return err
+	}
+	if len(problems) == 0 {
+		fmt.Printf("No problems found in %s.\n", filepath.Base(path))
+		return nil
+	}
+	fmt.Printf("Fixed %d problems; the original is kept in %s.\n", len(problems), filepath.Base(backupPath(path)))
+	return nil
+}
+
+// confirm asks a yes/no question on stderr and reads the answer from stdin.
+func confirm(question string) bool {
+	fmt.Fprint(os.Stderr, question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// resolveFile returns the path of the session named in args (see Resolve),
+// or of the current session if args is empty.
+func (s *Session) resolveFile(args []string) (string, error) {
+	if len(args) > 0 {
+		return s.resolveTarget(args[0])
+	}
+	return s.resolveTarget("")
+}
+
+// resolveTarget returns the path of the session ref, or of the pinned or
+// current session if ref is empty.
+func (s *Session) resolveTarget(ref string) (string, error) {
+	if ref == "" {
+		ref = s.Config.Settings.Session
+	}
+	name := ""
+	if ref != "" {
+		var err error
+		if name, err = s.Resolve(ref); err != nil {
+			return "", err
+		}
+	} else {
+		current, err := s.GetCurrentLogFile()
+		if err != nil {
+			return "", fmt.Errorf("no current session")
+		}
+		name = current
+	}
+
+	path := filepath.Join(s.SessionDir, filepath.Base(name))
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("session file not found: %s", name)
+	}
+	return path, nil
+}
+
 func showLog(id, streamName string) error {
 	config, err := NewConfig()
 	if err != nil {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// loadSnapshotTurn opens the snapshots of the current session and finds
+// turn n (0 for the last one).
+func loadSnapshotTurn(n int) (*Session, *Snapshots, snapshotTurn, error) {
+	config, err := NewConfig()
+	if err != nil {
+		return nil, nil, snapshotTurn{}, err
+	}
+
+	session := NewSession(config)
+	path, err := session.resolveFile(nil)
+	if err != nil {
+		return nil, nil, snapshotTurn{}, err
+	}
+	session.LogFile = path
+
+	turns, err := readSnapshotTurns(path)
+	if err != nil {
+		return nil, nil, snapshotTurn{}, err
+	}
+	turn, err := findSnapshotTurn(turns, n)
+	if err != nil {
+		return nil, nil, snapshotTurn{}, err
+	}
+	snapshots, err := NewSnapshots(config, sessionID(filepath.Base(path)))
+	if err != nil {
+		return nil, nil, snapshotTurn{}, err
+	}
+	return session, snapshots, turn, nil
+}
+
+func NewDiffCmd() *cobra.Command {
+	var turn int
+	var stat bool
+	cmd := &cobra.Command{
+		Use:   "diff",
+		Short: "Show the file changes made by the tool calls of a turn",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			_, snapshots, t, err := loadSnapshotTurn(turn)
+			if err != nil {
+				return err
+			}
+			if t.Undone {
+				fmt.Fprintf(os.Stderr, "Turn %d was undone.\n", t.Turn)
+			}
+			return snapshots.PrintDiff(os.Stdout, t, stat)
+		},
+	}
+	cmd.Flags().IntVar(&turn, "turn", 0, "Tool-call turn to show (default: the last one)")
+	cmd.Flags().BoolVar(&stat, "stat", false, "Only show which files changed")
+	return cmd
+}
+
+func NewUndoCmd() *cobra.Command {
+	var turn int
+	cmd := &cobra.Command{
+		Use:   "undo",
+		Short: "Revert the file changes made by the tool calls of a turn",
+		Long: `Revert the file changes made by the tool calls of a turn (by default the
+last one not undone yet). Nothing is changed if the files were modified
+again since in a way that conflicts.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			session, snapshots, t, err := loadSnapshotTurn(turn)
+			if err != nil {
+				return err
+			}
+			if t.Undone {
+				return fmt.Errorf("turn %d was already undone", t.Turn)
+			}
+
+			lock, err := session.Lock(cmd.Context(), false)
+			if err != nil {
+				return err
+			}
+			defer lock.Unlock()
+
+			if err := snapshots.Undo(t); err != nil {
+				return err
+			}
+			if err := session.AddEvent(UndoEvent{Type: EventUndo, Time: time.Now(), Turn: t.Turn}); err != nil {
+				return err
+			}
+			fmt.Printf("Reverted the changes of turn %d.\n", t.Turn)
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&turn, "turn", 0, "Tool-call turn to revert (default: the last one not undone)")
+	return cmd
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const DefaultCompactKeep = 10
+
+// SummaryPrefix starts the synthetic message that replaces compacted turns.
+const SummaryPrefix = "Summary of the earlier conversation (older messages were compacted):\n\n"
+
+const compactionPrompt = `You summarize a conversation between a user and an autonomous agent that works by running bash commands.
+Write a concise summary that lets the agent continue the task without the original messages.
+Include the user's goals and instructions, the important facts discovered, files created or changed, commands that worked or failed, and what remains to be done.
+Do not invent anything that is not in the conversation.`
+
+// maxSummaryInput caps how much of a single message is sent to the summarizer.
+const maxSummaryInput = 4000
+
+// EstimateTokens roughly estimates the prompt size of msgs, assuming about
+// four bytes per token plus a small overhead per message.
+func EstimateTokens(msgs []Message) int {
+	total := 0
+	for _, m := range msgs {
+		n := len(m.Content) + len(m.ReasoningContent)
+		for _, tc := range m.ToolCalls {
+			n += len(tc.Function.Name) + len(tc.Function.Arguments)
+		}
+		total += 4 + (n+3)/4
+	}
+	return total
+}
+
+// compactionCut returns the index of the first message kept after
+// compaction, or 0 if there is nothing to compact. The kept tail never
+// starts with a tool result, so tool calls stay paired with their results.
+func compactionCut(msgs []Message, keep int) int {
+	start := 0
+	if len(msgs) > 0 && msgs[0].Role == RoleSystem {
+		start = 1
+	}
+
+	cut := len(msgs) - keep
+	for cut > start && cut < len(msgs) && msgs[cut].Role == RoleTool {
+		cut--
+	}
+	if cut <= start {
+		return 0
+	}
+	return cut
+}
+
+// Compact summarizes all but the last keep messages of the session. It
+// returns the number of messages that were replaced.
+func (a *Agent) Compact(ctx context.Context, keep int) (int, error) {
+	if keep <= 0 {
+		keep = DefaultCompactKeep
+	}
+
+	msgs := a.Session.Messages
+	cut := compactionCut(msgs, keep)
+	if cut == 0 {
+		return 0, nil
+	}
+	start := 0
+	if msgs[0].Role == RoleSystem {
+		start = 1
+	}
+
+	summary, err := a.summarize(ctx, msgs[start:cut])
+	if err != nil {
+		return 0, fmt.Errorf("failed to summarize session: %w", err)
+	}
+
+	if err := a.Session.Compact(summary, len(msgs)-cut); err != nil {
+		return 0, err
+	}
+	return cut - start, nil
+}
+
+// maybeCompact compacts the session when it exceeds the configured budget.
+// When the kept messages alone exceed it, compacting would only summarize
+// the previous summary again, so it warns instead.
+func (a *Agent) maybeCompact(ctx context.Context) error {
+	budget := a.Config.Settings.ContextBudget
+	if budget <= 0 {
+		return nil
+	}
+
+	estimate := EstimateTokens(a.Session.Messages)
+	if estimate <= budget {
+		return nil
+	}
+
+	keep := a.Config.Settings.CompactKeep
+	if keep <= 0 {
+		keep = DefaultCompactKeep
+	}
+	if !worthCompacting(a.Session.Messages, keep) {
+		a.warnBudget(estimate, budget)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Compacting session (about %d tokens, budget %d)...\n", estimate, budget)
+	if _, err := a.Compact(ctx, keep); err != nil {
+		return err
+	}
+	if estimate := EstimateTokens(a.Session.Messages); estimate > budget {
+		a.warnBudget(estimate, budget)
+	}
+	return nil
+}
+
+// worthCompacting reports whether msgs have anything to compact besides
+// the summary of an earlier compaction.
+func worthCompacting(msgs []Message, keep int) bool {
+	cut := compactionCut(msgs, keep)
+	if cut == 0 {
+		return false
+	}
+	start := 0
+	if msgs[0].Role == RoleSystem {
+		start = 1
+	}
+	return cut-start > 1 || !strings.HasPrefix(msgs[start].Content, SummaryPrefix)
+}
+
+// warnBudget tells once per agent that compaction cannot get the session
+// under the budget.
+func (a *Agent) warnBudget(estimate, budget int) {
+	if a.budgetWarned {
+		return
+	}
+	a.budgetWarned = true
+	fmt.Fprintf(os.Stderr, "The last messages kept by compaction are about %d tokens, over context_budget %d; lower compact_keep or raise the budget.\n", estimate, budget)
+}
+
+func (a *Agent) summarize(ctx context.Context, msgs []Message) (string, error) {
+	resp, latency, err := a.complete(ctx, CompletionRequest{
+		Messages: []Message{
+			{Role: RoleSystem, Content: compactionPrompt},
+			{Role: RoleUser, Content: renderTranscript(msgs)},
+		},
+	}, PurposeCompaction, display{})
+	if err != nil {
+		return "", err
+	}
+
+	summary := strings.TrimSpace(resp.Message.Content)
+	if summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	if err := a.recordUsage(resp.Model, resp.Usage, latency, PurposeCompaction); err != nil {
+		return "", err
+	}
+	return summary, nil
+}
+
+// renderTranscript formats msgs as plain text for the summarizer.
+func renderTranscript(msgs []Message) string {
+	var b strings.Builder
+	for _, m := range msgs {
+		switch m.Role {
+		case RoleTool:
+			fmt.Fprintf(&b, "[TOOL RESULT]\n%s\n\n", clip(m.Content, maxSummaryInput))
+		default:
+			if m.Content != "" {
+				fmt.Fprintf(&b, "[%s]\n%s\n\n", strings.ToUpper(m.Role), clip(m.Content, maxSummaryInput))
+			}
+			for _, tc := range m.ToolCalls {
+				fmt.Fprintf(&b, "[TOOL CALL]\n%s\n\n", clip(tc.Function.Arguments, maxSummaryInput))
+			}
+		}
+	}
+	return b.String()
+}
+
+func clip(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	return s[:limit] + "\n... (clipped)"
+}
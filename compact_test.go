@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCompactionCut(t *testing.T) {
+	msgs := []Message{
+		{Role: RoleSystem, Content: "sys"},
+		{Role: RoleUser, Content: "do it"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "1"}}},
+		{Role: RoleTool, ToolCallID: "1", Content: "ok"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "2"}}},
+		{Role: RoleTool, ToolCallID: "2", Content: "ok"},
+		{Role: RoleAssistant, Content: "done"},
+	}
+
+	if cut := compactionCut(msgs, 2); cut != 4 {
+		t.Errorf("expected cut 4 (before the tool call), got %d", cut)
+	}
+	if cut := compactionCut(msgs, 3); cut != 4 {
+		t.Errorf("expected cut 4, got %d", cut)
+	}
+	if cut := compactionCut(msgs, 6); cut != 0 {
+		t.Errorf("expected nothing to compact, got %d", cut)
+	}
+	if cut := compactionCut(msgs, 10); cut != 0 {
+		t.Errorf("expected nothing to compact, got %d", cut)
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	short := []Message{{Role: RoleUser, Content: "hi"}}
+	long := []Message{{Role: RoleUser, Content: strings.Repeat("a", 4000)}}
+	if EstimateTokens(short) >= EstimateTokens(long) {
+		t.Errorf("expected longer content to have more tokens")
+	}
+	if n := EstimateTokens(long); n < 1000 || n > 1010 {
+		t.Errorf("expected about 1000 tokens, got %d", n)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"The user asked for ls."}}]}`)
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "saa-test-compact")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		SaaDir:   filepath.Join(tmpDir, ".saa"),
+		Settings: Settings{APIURL: server.URL, Model: "test"},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+
+	for _, msg := range []Message{
+		{Role: RoleUser, Content: "run ls"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "1", Function: FunctionCall{Name: "bash", Arguments: `{"command":"ls"}`}}}},
+		{Role: RoleTool, ToolCallID: "1", Content: "a.txt"},
+		{Role: RoleAssistant, Content: "There is a.txt."},
+		{Role: RoleUser, Content: "thanks"},
+	} {
+		if err := session.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+
+	agent := NewAgent(config, session)
+	n, err := agent.Compact(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Compact failed: %v", err)
+	}
+	if n != 4 {
+		t.Errorf("expected 4 compacted messages, got %d", n)
+	}
+
+	check := func(msgs []Message) {
+		t.Helper()
+		if len(msgs) != 3 {
+			t.Fatalf("expected 3 messages after compaction, got %d", len(msgs))
+		}
+		if msgs[0].Role != RoleSystem {
+			t.Errorf("expected system prompt first, got %q", msgs[0].Role)
+		}
+		if msgs[1].Content != SummaryPrefix+"The user asked for ls." {
+			t.Errorf("unexpected summary message %q", msgs[1].Content)
+		}
+		if msgs[2].Content != "thanks" {
+			t.Errorf("expected last message to be kept, got %q", msgs[2].Content)
+		}
+	}
+	check(session.Messages)
+
+	// The original messages stay in the file, and loading rebuilds the compacted view.
+	raw, err := os.ReadFile(session.LogFile)
+	if err != nil {
+		t.Fatalf("failed to read session file: %v", err)
+	}
+	if !strings.Contains(string(raw), "There is a.txt.") {
+		t.Errorf("expected original messages to be kept in the session file")
+	}
+
+	reloaded := NewSession(config)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	check(reloaded.Messages)
+
+	// Messages added after the compaction are appended to the compacted view.
+	if err := reloaded.AddMessage(Message{Role: RoleUser, Content: "more"}); err != nil {
+		t.Fatalf("AddMessage failed: %v", err)
+	}
+	again := NewSession(config)
+	if err := again.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(again.Messages) != 4 || again.Messages[3].Content != "more" {
+		t.Errorf("unexpected messages after reload: %+v", again.Messages)
+	}
+}
+
+func TestMaybeCompactOverBudget(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"Still ls."}}]}`)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	config := &Config{
+		SaaDir:   filepath.Join(tmpDir, ".saa"),
+		Settings: Settings{APIURL: server.URL, Model: "test", ContextBudget: 50, CompactKeep: 2},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	for _, msg := range []Message{
+		{Role: RoleUser, Content: "run ls"},
+		{Role: RoleAssistant, Content: "ok"},
+		{Role: RoleUser, Content: strings.Repeat("long ", 100)},
+		{Role: RoleAssistant, Content: strings.Repeat("long ", 100)},
+	} {
+		if err := session.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+
+	// The kept tail alone is over the budget: the first call compacts the
+	// older messages, later ones leave the summary alone.
+	agent := NewAgent(config, session)
+	for range 3 {
+		if err := agent.maybeCompact(context.Background()); err != nil {
+			t.Fatalf("maybeCompact failed: %v", err)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected one summary request, got %d", requests)
+	}
+	if len(session.Messages) != 4 || !strings.HasPrefix(session.Messages[1].Content, SummaryPrefix) {
+		t.Errorf("unexpected messages %+v", session.Messages)
+	}
+	if !agent.budgetWarned {
+		t.Error("expected a warning about the budget")
+	}
+}
@@ -21,6 +21,7 @@ type Config struct {
 
 type Settings struct {
 	APIKey           string `mapstructure:"api_key" json:"api_key,omitempty"`
+	Provider         string `mapstructure:"provider" json:"provider,omitempty"`
 	APIURL           string `mapstructure:"api_url" json:"api_url,omitempty"`
 	Model            string `mapstructure:"model" json:"model,omitempty"`
 	SessionDir       string `mapstructure:"session_dir" json:"session_dir,omitempty"`
@@ -31,6 +32,33 @@ type Settings struct {
 	ShowToolResult   bool   `mapstructure:"show_tool_result" json:"show_tool_result,omitempty"`
 	ShowReasoning    bool   `mapstructure:"show_reasoning" json:"show_reasoning,omitempty"`
 	Verbose          bool   `mapstructure:"verbose" json:"verbose,omitempty"`
+	Stream           bool   `mapstructure:"stream" json:"stream,omitempty"`
+	PersistentShell  bool   `mapstructure:"persistent_shell" json:"persistent_shell,omitempty"`
+	ContextBudget    int    `mapstructure:"context_budget" json:"context_budget,omitempty"`
+	CompactKeep      int    `mapstructure:"compact_keep" json:"compact_keep,omitempty"`
+	MaxTurns         int    `mapstructure:"max_turns" json:"max_turns,omitempty"`
+	MaxTotalTokens   int    `mapstructure:"max_total_tokens" json:"max_total_tokens,omitempty"`
+	MaxTime          string `mapstructure:"max_time" json:"max_time,omitempty"`
+	RetryMaxAttempts int    `mapstructure:"retry_max_attempts" json:"retry_max_attempts,omitempty"`
+	RetryMaxDelay    string `mapstructure:"retry_max_delay" json:"retry_max_delay,omitempty"`
+	RequestTimeout   string `mapstructure:"request_timeout" json:"request_timeout,omitempty"`
+	ShowUsage        bool   `mapstructure:"show_usage" json:"show_usage,omitempty"`
+	SearchIndex      bool   `mapstructure:"search_index" json:"search_index,omitempty"`
+	AutoTitle        string `mapstructure:"auto_title" json:"auto_title,omitempty"`
+	Session          string `mapstructure:"session" json:"-"`
+	SessionWait      bool   `mapstructure:"session_wait" json:"session_wait,omitempty"`
+	Snapshots        bool   `mapstructure:"snapshots" json:"snapshots,omitempty"`
+	TextTools        bool   `mapstructure:"text_tools" json:"text_tools,omitempty"`
+	ParallelTools    int    `mapstructure:"parallel_tools" json:"parallel_tools,omitempty"`
+	ServeToken       string `mapstructure:"serve_token" json:"serve_token,omitempty"`
+
+	Prices    map[string]ModelPrice `mapstructure:"prices" json:"prices,omitempty"`
+	Fallbacks []ModelEndpoint       `mapstructure:"fallbacks" json:"fallbacks,omitempty"`
+	Routing   RoutingSettings       `mapstructure:"routing" json:"routing,omitzero"`
+	Request   RequestSettings       `mapstructure:"request" json:"request,omitzero"`
+	Prune     PruneSettings         `mapstructure:"prune" json:"prune,omitzero"`
+	Policy    PolicySettings        `mapstructure:"policy" json:"policy,omitzero"`
+	Sandbox   SandboxSettings       `mapstructure:"sandbox" json:"sandbox,omitzero"`
 }
 
 func (c *Config) ResolveSystemPrompt() (string, error) {
@@ -173,8 +201,19 @@ func (c *Config) SaveConfig() error {
 }
 
 func (c *Config) Validate() error {
+	if !validProvider(c.Settings.Provider) {
+		return fmt.Errorf("unknown provider: %s (use openai, anthropic or ollama)", c.Settings.Provider)
+	}
+	for i, ep := range c.Settings.Fallbacks {
+		if ep.Model == "" {
+			return fmt.Errorf("fallback %d has no model", i+1)
+		}
+		if !validProvider(ep.Provider) {
+			return fmt.Errorf("unknown provider of fallback %s: %s", ep.Model, ep.Provider)
+		}
+	}
 	var missing []string
-	if c.Settings.APIURL == "" {
+	if c.Settings.APIURL == "" && defaultAPIURL(c.Settings.Provider) == "" {
 		missing = append(missing, "api_url")
 	}
 	if c.Settings.Model == "" {
@@ -3,6 +3,7 @@ package main
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/spf13/viper"
@@ -86,7 +87,8 @@ func TestConfigLoading(t *testing.T) {
 	configContent := `{
 		"api_key": "test_key",
 		"model": "gpt-4",
-		"max_stdout": 500
+		"max_stdout": 500,
+		"serve_token": "test_token"
 	}`
 	if err := os.WriteFile(configFile, []byte(configContent), 0644); err != nil {
 		t.Fatalf("failed to write config file: %v", err)
@@ -114,6 +116,15 @@ func TestConfigLoading(t *testing.T) {
 		t.Errorf("expected MaxStdout 500, got %d", config.Settings.MaxStdout)
 	}
 
+	// Saving must keep settings it does not change.
+	if err := config.SaveConfig(); err != nil {
+		t.Fatalf("SaveConfig failed: %v", err)
+	}
+	saved, _ := os.ReadFile(configFile)
+	if !strings.Contains(string(saved), `"serve_token": "test_token"`) {
+		t.Errorf("SaveConfig dropped serve_token:\n%s", saved)
+	}
+
 	// Test Environment Variable Override
 	os.Setenv("SAA_MODEL", "gpt-3.5-turbo")
 	defer os.Unsetenv("SAA_MODEL")
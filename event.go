@@ -0,0 +1,110 @@
+package main
+
+import "time"
+
+// Event types stored in the "saa" field of non-message session lines.
+const (
+	EventCompaction = "compaction"
+	EventPolicy     = "policy"
+	EventStop       = "stop"
+	EventUsage      = "usage"
+	EventFork       = "fork"
+	EventRewind     = "rewind"
+	EventSnapshot   = "snapshot"
+	EventUndo       = "undo"
+)
+
+// CompactionEvent marks that the messages before it were summarized. When
+// loading, everything after the system prompt except the last Keep messages
+// is replaced by Summary.
+type CompactionEvent struct {
+	Type    string    `json:"saa"`
+	Time    time.Time `json:"time"`
+	Summary string    `json:"summary"`
+	Keep    int       `json:"keep"`
+}
+
+// PolicyEvent records how the command policy handled a tool call. By is
+// "policy", "user", "always" (approved earlier in this run) or
+// "non-interactive".
+type PolicyEvent struct {
+	Type       string    `json:"saa"`
+	Time       time.Time `json:"time"`
+	ToolCallID string    `json:"tool_call_id"`
+	Command    string    `json:"command"`
+	Decision   string    `json:"decision"`
+	Rule       string    `json:"rule,omitempty"`
+	By         string    `json:"by"`
+	Edited     string    `json:"edited,omitempty"`
+}
+
+// StopEvent records that a run was stopped by a budget before the model
+// finished on its own. Reason is one of the Stop* constants.
+type StopEvent struct {
+	Type   string    `json:"saa"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Limit  string    `json:"limit"`
+	Turns  int       `json:"turns"`
+	Tokens int       `json:"tokens"`
+}
+
+// UsageEvent records the token usage and latency of one model request. It
+// follows the assistant message it produced. Purpose is empty for agent
+// turns and "compaction" for summaries.
+type UsageEvent struct {
+	Type             string    `json:"saa"`
+	Time             time.Time `json:"time"`
+	Model            string    `json:"model"`
+	Purpose          string    `json:"purpose,omitempty"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	ReasoningTokens  int       `json:"reasoning_tokens,omitempty"`
+	CachedTokens     int       `json:"cached_tokens,omitempty"`
+	TotalTokens      int       `json:"total_tokens"`
+	LatencyMS        int64     `json:"latency_ms"`
+}
+
+// ForkEvent is the first line of a forked session. It names the session it
+// was copied from and how many user turns were copied.
+type ForkEvent struct {
+	Type   string    `json:"saa"`
+	Time   time.Time `json:"time"`
+	Parent string    `json:"parent"`
+	At     int       `json:"at"`
+}
+
+// RewindEvent is the first line of a rewind sidecar. Size is the length of
+// the session file right after the rewind, so a restore can tell whether it
+// changed since.
+type RewindEvent struct {
+	Type string    `json:"saa"`
+	Time time.Time `json:"time"`
+	Size int64     `json:"size"`
+}
+
+// SnapshotEvent follows the result of a tool call when snapshots are on.
+// Before and After are the project trees in the shadow repository under
+// .saa/snapshots.
+type SnapshotEvent struct {
+	Type       string    `json:"saa"`
+	Time       time.Time `json:"time"`
+	ToolCallID string    `json:"tool_call_id"`
+	Command    string    `json:"command"`
+	Before     string    `json:"before"`
+	After      string    `json:"after"`
+}
+
+// UndoEvent records that `saa undo` reverted the changes of a turn.
+type UndoEvent struct {
+	Type string    `json:"saa"`
+	Time time.Time `json:"time"`
+	Turn int       `json:"turn"`
+}
+
+func (e PolicyEvent) describeRule() string {
+	if e.Rule == "" {
+		return "default policy"
+	}
+	return e.Rule
+}
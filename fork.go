@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// readSessionLines returns the raw lines of a session file, without their
+// trailing newlines.
+func readSessionLines(path string) ([][]byte, error) {
+	var lines [][]byte
+	err := eachLine(path, func(line []byte) error {
+		lines = append(lines, line)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+func writeSessionLines(path string, lines [][]byte) error {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return writeFileAtomic(path, buf.Bytes(), 0644)
+}
+
+// userTurnStarts returns the indexes of the lines holding user messages.
+// Each starts a turn that runs until the next one.
+func userTurnStarts(lines [][]byte) []int {
+	var starts []int
+	for i, line := range lines {
+		var probe struct {
+			Type string `json:"saa"`
+			Role string `json:"role"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			continue
+		}
+		if probe.Type == "" && probe.Role == RoleUser {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+func newSessionFilename() string {
+	return fmt.Sprintf("%s_%s.jsonl", time.Now().Format("20060102-150405"), newID())
+}
+
+// Fork copies the first at turns of the session file src into a new session,
+// which becomes current. A negative at copies all turns. The new file
+// starts with a fork event naming its parent.
+func (s *Session) Fork(src string, at int) (string, error) {
+	lines, err := readSessionLines(src)
+	if err != nil {
+		return "", err
+	}
+
+	starts := userTurnStarts(lines)
+	if at > len(starts) {
+		return "", fmt.Errorf("session has only %d turns", len(starts))
+	}
+	if at >= 0 && at < len(starts) {
+		lines = lines[:starts[at]]
+	} else {
+		at = len(starts)
+	}
+
+	ev, err := json.Marshal(ForkEvent{
+		Type:   EventFork,
+		Time:   time.Now(),
+		Parent: filepath.Base(src),
+		At:     at,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	filename := newSessionFilename()
+	path := filepath.Join(s.SessionDir, filename)
+	if err := writeSessionLines(path, append([][]byte{ev}, withoutForkEvents(lines)...)); err != nil {
+		return "", err
+	}
+
+	if parent, err := ReadMeta(src); err == nil {
+		meta := SessionMeta{Tags: parent.Tags, Model: parent.Model}
+		if parent.Title != "" {
+			meta.Title = parent.Title + " (fork)"
+		}
+		if err := writeMeta(path, meta); err != nil {
+			return "", err
+		}
+	}
+	if err := refreshMeta(path, s.Config.Settings); err != nil {
+		return "", err
+	}
+	if err := s.Switch(filename); err != nil {
+		return "", err
+	}
+	return filename, nil
+}
+
+// withoutForkEvents drops the lineage of the parent, so that a file only
+// names its own parent.
+func withoutForkEvents(lines [][]byte) [][]byte {
+	var out [][]byte
+	for _, line := range lines {
+		if ev, err := decodeEvent[ForkEvent](line); err == nil && ev.Type == EventFork {
+			continue
+		}
+		out = append(out, line)
+	}
+	return out
+}
+
+// rewoundPath returns the sidecar holding the turns removed by rewind.
+func rewoundPath(logFile string) string {
+	return strings.TrimSuffix(logFile, ".jsonl") + ".rewound"
+}
+
+// Rewind removes the last n user turns from the current session file. The
+// removed lines are kept in a sidecar, in front of what earlier rewinds
+// removed, so that Restore can put them back. It returns the number of turns
+// removed.
+func (s *Session) Rewind(n int) (int, error) {
+	lines, err := readSessionLines(s.LogFile)
+	if err != nil {
+		return 0, err
+	}
+
+	starts := userTurnStarts(lines)
+	n = min(n, len(starts))
+	if n <= 0 {
+		return 0, nil
+	}
+	cut := starts[len(starts)-n]
+	kept, tail := lines[:cut], lines[cut:]
+
+	sidecar := rewoundPath(s.LogFile)
+	var earlier [][]byte
+	if old, err := readSessionLines(sidecar); err == nil && len(old) > 0 {
+		earlier = old[1:]
+	}
+
+	var size int64
+	for _, line := range kept {
+		size += int64(len(line)) + 1
+	}
+	header, err := json.Marshal(RewindEvent{Type: EventRewind, Time: time.Now(), Size: size})
+	if err != nil {
+		return 0, err
+	}
+
+	restore := append([][]byte{header}, tail...)
+	if err := writeSessionLines(sidecar, append(restore, earlier...)); err != nil {
+		return 0, err
+	}
+	if err := writeSessionLines(s.LogFile, kept); err != nil {
+		return 0, err
+	}
+	if err := s.UpdateMeta(); err != nil {
+		return 0, err
+	}
+	return n, s.loadMessages()
+}
+
+// Restore appends the turns removed by Rewind back to the session file. It
+// fails if the session changed since the rewind.
+func (s *Session) Restore() (int, error) {
+	sidecar := rewoundPath(s.LogFile)
+	lines, err := readSessionLines(sidecar)
+	if os.IsNotExist(err) || (err == nil && len(lines) == 0) {
+		return 0, fmt.Errorf("nothing to restore")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	header, err := decodeEvent[RewindEvent](lines[0])
+	if err != nil || header.Type != EventRewind {
+		return 0, fmt.Errorf("invalid rewind file: %s", sidecar)
+	}
+	fi, err := os.Stat(s.LogFile)
+	if err != nil {
+		return 0, err
+	}
+	if fi.Size() != header.Size {
+		return 0, fmt.Errorf("session changed since it was rewound; fork it to keep both versions")
+	}
+
+	current, err := readSessionLines(s.LogFile)
+	if err != nil {
+		return 0, err
+	}
+	tail := lines[1:]
+	if err := writeSessionLines(s.LogFile, append(current, tail...)); err != nil {
+		return 0, err
+	}
+	if err := os.Remove(sidecar); err != nil {
+		return 0, err
+	}
+	if err := s.UpdateMeta(); err != nil {
+		return 0, err
+	}
+	return len(userTurnStarts(tail)), s.loadMessages()
+}
+
+// sessionParent returns the fork event of a session file, if it was forked.
+func sessionParent(path string) (ForkEvent, bool) {
+	var parent ForkEvent
+	found := false
+	scanSessionFile(path, func(typ string, line []byte) error {
+		if typ == EventFork && !found {
+			if ev, err := decodeEvent[ForkEvent](line); err == nil {
+				parent, found = ev, true
+			}
+		}
+		return nil
+	})
+	return parent, found
+}
+
+// PrintLineage writes the ancestors of the session file name, the file
+// itself (marked with *) and the sessions forked from it.
+func (s *Session) PrintLineage(w io.Writer, name string) error {
+	name = filepath.Base(name)
+
+	chain := []string{name}
+	at := map[string]int{}
+	seen := map[string]bool{name: true}
+	for cur := name; ; {
+		ev, ok := sessionParent(filepath.Join(s.SessionDir, cur))
+		if !ok {
+			break
+		}
+		at[cur] = ev.At
+		if seen[ev.Parent] {
+			break
+		}
+		seen[ev.Parent] = true
+		chain = append([]string{ev.Parent}, chain...)
+		cur = ev.Parent
+	}
+
+	files, err := s.List()
+	if err != nil {
+		return err
+	}
+	var children []string
+	for i := len(files) - 1; i >= 0; i-- {
+		f := files[i]
+		if ev, ok := sessionParent(filepath.Join(s.SessionDir, f)); ok && ev.Parent == name {
+			children = append(children, f)
+			at[f] = ev.At
+		}
+	}
+
+	describe := func(f string) string {
+		if _, err := os.Stat(filepath.Join(s.SessionDir, f)); err != nil {
+			return f + " (deleted)"
+		}
+		if n, ok := at[f]; ok {
+			return fmt.Sprintf("%s (forked at turn %d)", f, n)
+		}
+		return f
+	}
+
+	for i, f := range chain {
+		marker := " "
+		if f == name {
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s %s%s\n", marker, strings.Repeat("  ", i), describe(f))
+	}
+	for _, f := range children {
+		fmt.Fprintf(w, "  %s%s\n", strings.Repeat("  ", len(chain)), describe(f))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestSession(t *testing.T, prompts ...string) *Session {
+	t.Helper()
+	config := &Config{SaaDir: filepath.Join(t.TempDir(), ".saa")}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	for _, p := range prompts {
+		for _, msg := range []Message{
+			{Role: RoleUser, Content: p},
+			{Role: RoleAssistant, Content: "answer to " + p},
+		} {
+			if err := session.AddMessage(msg); err != nil {
+				t.Fatalf("AddMessage failed: %v", err)
+			}
+		}
+	}
+	return session
+}
+
+func TestFork(t *testing.T) {
+	session := newTestSession(t, "one", "two", "three")
+	parent := session.LogFile
+
+	filename, err := session.Fork(parent, 2)
+	if err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+
+	forked := NewSession(session.Config)
+	if err := forked.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if filepath.Base(forked.LogFile) != filename {
+		t.Errorf("expected the fork to become current, got %s", forked.LogFile)
+	}
+	if len(forked.Messages) != 5 || forked.Messages[4].Content != "answer to two" {
+		t.Errorf("unexpected forked messages: %+v", forked.Messages)
+	}
+
+	ev, ok := sessionParent(forked.LogFile)
+	if !ok || ev.Parent != filepath.Base(parent) || ev.At != 2 {
+		t.Errorf("unexpected fork event %+v", ev)
+	}
+
+	// Forking a fork only records the direct parent.
+	grandchild, err := session.Fork(forked.LogFile, -1)
+	if err != nil {
+		t.Fatalf("Fork failed: %v", err)
+	}
+	raw, _ := os.ReadFile(filepath.Join(session.SessionDir, grandchild))
+	if n := strings.Count(string(raw), `"saa":"fork"`); n != 1 {
+		t.Errorf("expected one fork event, got %d", n)
+	}
+
+	var out bytes.Buffer
+	if err := session.PrintLineage(&out, forked.LogFile); err != nil {
+		t.Fatalf("PrintLineage failed: %v", err)
+	}
+	expected := "  " + filepath.Base(parent) + "\n" +
+		"*   " + filename + " (forked at turn 2)\n" +
+		"      " + grandchild + " (forked at turn 2)\n"
+	if out.String() != expected {
+		t.Errorf("expected lineage:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	if _, err := session.Fork(parent, 4); err == nil {
+		t.Errorf("expected an error when forking past the last turn")
+	}
+}
+
+func TestRewindAndRestore(t *testing.T) {
+	session := newTestSession(t, "one", "two", "three")
+	original, err := os.ReadFile(session.LogFile)
+	if err != nil {
+		t.Fatalf("failed to read session file: %v", err)
+	}
+
+	if n, err := session.Rewind(1); err != nil || n != 1 {
+		t.Fatalf("Rewind failed: %d %v", n, err)
+	}
+	if n, err := session.Rewind(1); err != nil || n != 1 {
+		t.Fatalf("Rewind failed: %d %v", n, err)
+	}
+	if len(session.Messages) != 3 || session.Messages[2].Content != "answer to one" {
+		t.Errorf("unexpected messages after rewind: %+v", session.Messages)
+	}
+
+	if n, err := session.Restore(); err != nil || n != 2 {
+		t.Fatalf("Restore failed: %d %v", n, err)
+	}
+	restored, _ := os.ReadFile(session.LogFile)
+	if !bytes.Equal(restored, original) {
+		t.Errorf("expected the original session after restore, got:\n%s", restored)
+	}
+	if _, err := os.Stat(rewoundPath(session.LogFile)); !os.IsNotExist(err) {
+		t.Errorf("expected the sidecar to be removed")
+	}
+
+	// A session that changed after the rewind is not restored over.
+	session.Rewind(1)
+	session.AddMessage(Message{Role: RoleUser, Content: "other"})
+	if _, err := session.Restore(); err == nil {
+		t.Errorf("expected restore to fail after the session changed")
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// errInterrupted is returned by ReadLine when the user presses Ctrl-C.
+var errInterrupted = errors.New("interrupted")
+
+const maxHistory = 1000
+
+// LineEditor reads lines from the terminal with basic Emacs-style editing
+// and history. When stdin is not a terminal it reads plain lines.
+type LineEditor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int
+	tty         bool
+	history     []string
+	historyFile string
+}
+
+func NewLineEditor(historyFile string) *LineEditor {
+	e := &LineEditor{
+		in:          bufio.NewReader(os.Stdin),
+		out:         os.Stdout,
+		fd:          int(os.Stdin.Fd()),
+		tty:         isTerminal(os.Stdin) && isTerminal(os.Stdout),
+		historyFile: historyFile,
+	}
+	e.loadHistory()
+	return e
+}
+
+func (e *LineEditor) loadHistory() {
+	if e.historyFile == "" {
+		return
+	}
+	data, err := os.ReadFile(e.historyFile)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.history = append(e.history, line)
+		}
+	}
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// AddHistory appends line to the in-memory history and the history file.
+func (e *LineEditor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[1:]
+	}
+
+	if e.historyFile == "" {
+		return
+	}
+	file, err := os.OpenFile(e.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+// ReadLine reads one line. It returns io.EOF on Ctrl-D at an empty line and
+// errInterrupted on Ctrl-C.
+func (e *LineEditor) ReadLine(prompt string) (string, error) {
+	if !e.tty {
+		line, err := e.in.ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restoreTerminal(e.fd, state)
+
+	ed := lineState{prompt: prompt, width: terminalWidth(e.fd), out: e.out}
+	histIdx := len(e.history)
+	saved := ""
+	ed.refresh()
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\n")
+			return string(ed.buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\n")
+			return "", errInterrupted
+		case 4: // Ctrl-D
+			if len(ed.buf) == 0 {
+				fmt.Fprint(e.out, "\n")
+				return "", io.EOF
+			}
+			ed.deleteForward()
+		case 1: // Ctrl-A
+			ed.pos = 0
+		case 5: // Ctrl-E
+			ed.pos = len(ed.buf)
+		case 2: // Ctrl-B
+			ed.move(-1)
+		case 6: // Ctrl-F
+			ed.move(1)
+		case 8, 127: // Backspace
+			ed.deleteBackward()
+		case 11: // Ctrl-K
+			ed.buf = ed.buf[:ed.pos]
+		case 21: // Ctrl-U
+			ed.buf = append([]rune{}, ed.buf[ed.pos:]...)
+			ed.pos = 0
+		case 23: // Ctrl-W
+			ed.deleteWord()
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 16, 14: // Ctrl-P, Ctrl-N
+			histIdx, saved = e.navigate(&ed, histIdx, saved, r == 16)
+		case 27: // Escape sequence
+			switch e.readEscape() {
+			case "A":
+				histIdx, saved = e.navigate(&ed, histIdx, saved, true)
+			case "B":
+				histIdx, saved = e.navigate(&ed, histIdx, saved, false)
+			case "C":
+				ed.move(1)
+			case "D":
+				ed.move(-1)
+			case "H", "1~", "7~":
+				ed.pos = 0
+			case "F", "4~", "8~":
+				ed.pos = len(ed.buf)
+			case "3~":
+				ed.deleteForward()
+			}
+		default:
+			if unicode.IsPrint(r) {
+				ed.insert(r)
+			}
+		}
+		ed.refresh()
+	}
+}
+
+// readEscape reads the rest of an escape sequence and returns it without the
+// leading "[" or "O", e.g. "A" for the up arrow or "3~" for delete.
+func (e *LineEditor) readEscape() string {
+	b, err := e.in.ReadByte()
+	if err != nil || (b != '[' && b != 'O') {
+		return ""
+	}
+	var seq []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, c)
+		if c >= 0x40 && c <= 0x7e {
+			return string(seq)
+		}
+	}
+}
+
+func (e *LineEditor) navigate(ed *lineState, idx int, saved string, up bool) (int, string) {
+	if idx == len(e.history) {
+		saved = string(ed.buf)
+	}
+	switch {
+	case up && idx > 0:
+		idx--
+	case !up && idx < len(e.history):
+		idx++
+	default:
+		return idx, saved
+	}
+
+	text := saved
+	if idx < len(e.history) {
+		text = e.history[idx]
+	}
+	ed.buf = []rune(text)
+	ed.pos = len(ed.buf)
+	return idx, saved
+}
+
+// lineState is the buffer being edited. The line is shown on a single row
+// and scrolls horizontally when it is wider than the terminal.
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+	width  int
+	out    io.Writer
+}
+
+func (l *lineState) insert(r rune) {
+	l.buf = append(l.buf, 0)
+	copy(l.buf[l.pos+1:], l.buf[l.pos:])
+	l.buf[l.pos] = r
+	l.pos++
+}
+
+func (l *lineState) move(n int) {
+	l.pos = min(max(l.pos+n, 0), len(l.buf))
+}
+
+func (l *lineState) deleteBackward() {
+	if l.pos == 0 {
+		return
+	}
+	l.buf = append(l.buf[:l.pos-1], l.buf[l.pos:]...)
+	l.pos--
+}
+
+func (l *lineState) deleteForward() {
+	if l.pos >= len(l.buf) {
+		return
+	}
+	l.buf = append(l.buf[:l.pos], l.buf[l.pos+1:]...)
+}
+
+func (l *lineState) deleteWord() {
+	start := l.pos
+	for start > 0 && unicode.IsSpace(l.buf[start-1]) {
+		start--
+	}
+	for start > 0 && !unicode.IsSpace(l.buf[start-1]) {
+		start--
+	}
+	l.buf = append(l.buf[:start], l.buf[l.pos:]...)
+	l.pos = start
+}
+
+func (l *lineState) refresh() {
+	avail := l.width - stringWidth(l.prompt) - 1
+	if avail < 10 {
+		avail = 10
+	}
+
+	// Pick a window of the buffer that contains the cursor.
+	start := 0
+	for runesWidth(l.buf[start:l.pos]) > avail {
+		start++
+	}
+	end := l.pos
+	for end < len(l.buf) && runesWidth(l.buf[start:end+1]) <= avail {
+		end++
+	}
+
+	visible := l.buf[start:end]
+	back := runesWidth(visible[l.pos-start:])
+	fmt.Fprintf(l.out, "\r%s%s\x1b[K", l.prompt, string(visible))
+	if back > 0 {
+		fmt.Fprintf(l.out, "\x1b[%dD", back)
+	}
+}
+
+func stringWidth(s string) int {
+	w := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		w += runeWidth(r)
+		s = s[size:]
+	}
+	return w
+}
+
+func runesWidth(rs []rune) int {
+	w := 0
+	for _, r := range rs {
+		w += runeWidth(r)
+	}
+	return w
+}
+
+// runeWidth returns the number of terminal columns r occupies, treating East
+// Asian wide and fullwidth characters as two columns.
+func runeWidth(r rune) int {
+	switch {
+	case r < 0x20 || unicode.Is(unicode.Mn, r):
+		return 0
+	case r >= 0x1100 && r <= 0x115f,
+		r >= 0x2e80 && r <= 0xa4cf && r != 0x303f,
+		r >= 0xac00 && r <= 0xd7a3,
+		r >= 0xf900 && r <= 0xfaff,
+		r >= 0xfe30 && r <= 0xfe4f,
+		r >= 0xff00 && r <= 0xff60,
+		r >= 0xffe0 && r <= 0xffe6,
+		r >= 0x1f300 && r <= 0x1faff,
+		r >= 0x20000 && r <= 0x3fffd:
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestLineStateEditing(t *testing.T) {
+	var out bytes.Buffer
+	l := lineState{prompt: "> ", width: 80, out: &out}
+
+	for _, r := range "hello world" {
+		l.insert(r)
+	}
+	l.deleteWord()
+	if string(l.buf) != "hello " {
+		t.Errorf("expected %q after deleting a word, got %q", "hello ", string(l.buf))
+	}
+
+	l.move(-100)
+	l.insert('>')
+	l.move(2)
+	l.deleteBackward()
+	l.deleteForward()
+	if string(l.buf) != ">hlo " || l.pos != 2 {
+		t.Errorf("unexpected buffer %q at %d", string(l.buf), l.pos)
+	}
+
+	l.refresh()
+	if !strings.HasSuffix(out.String(), "\r> >hlo \x1b[K\x1b[3D") {
+		t.Errorf("unexpected refresh output %q", out.String())
+	}
+}
+
+func TestLineStateScrolling(t *testing.T) {
+	var out bytes.Buffer
+	l := lineState{prompt: "> ", width: 20, out: &out}
+	for _, r := range strings.Repeat("x", 30) + "end" {
+		l.insert(r)
+	}
+
+	l.refresh()
+	line := out.String()
+	if !strings.Contains(line, "xxxend\x1b[K") {
+		t.Errorf("expected the end of the line to be visible, got %q", line)
+	}
+	if visible := strings.Count(line, "x"); visible > 17 {
+		t.Errorf("expected the line to scroll, got %d visible characters", visible)
+	}
+}
+
+func TestRuneWidth(t *testing.T) {
+	if w := stringWidth("abc"); w != 3 {
+		t.Errorf("expected width 3, got %d", w)
+	}
+	if w := stringWidth("日本語"); w != 6 {
+		t.Errorf("expected width 6, got %d", w)
+	}
+}
+
+func TestReplReadInput(t *testing.T) {
+	input := "first line \\\nsecond\n\"\"\"\nblock one\nblock two\n\"\"\"\nsingle\n"
+	editor := &LineEditor{in: bufio.NewReader(strings.NewReader(input))}
+	r := &repl{editor: editor}
+
+	expected := []string{"first line \nsecond", "block one\nblock two", "single"}
+	for _, e := range expected {
+		got, err := r.readInput()
+		if err != nil {
+			t.Fatalf("readInput failed: %v", err)
+		}
+		if got != e {
+			t.Errorf("expected %q, got %q", e, got)
+		}
+	}
+
+	if _, err := r.readInput(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+	if len(editor.history) != 7 {
+		t.Errorf("expected 7 history entries, got %d", len(editor.history))
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrSessionBusy is returned when another process holds the lock of a session.
+var ErrSessionBusy = errors.New("session is busy")
+
+// lockPollInterval is how often a waiting process retries a busy lock.
+const lockPollInterval = 200 * time.Millisecond
+
+// lockPath returns the file locked while a session is in use. It is separate
+// from the session file, which is replaced on every Save and rewind.
+func lockPath(logFile string) string {
+	return strings.TrimSuffix(logFile, ".jsonl") + ".lock"
+}
+
+// SessionLock is an advisory lock (flock) on a session. It is released when
+// the process exits, so a crash never leaves a session locked.
+type SessionLock struct {
+	file *os.File
+}
+
+// tryLock takes the lock of logFile without blocking.
+func tryLock(logFile string) (*SessionLock, error) {
+	file, err := os.OpenFile(lockPath(logFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		file.Close()
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return nil, ErrSessionBusy
+		}
+		return nil, err
+	}
+	return &SessionLock{file: file}, nil
+}
+
+// Unlock releases the lock.
+func (l *SessionLock) Unlock() {
+	if l == nil {
+		return
+	}
+	unix.Flock(int(l.file.Fd()), unix.LOCK_UN)
+	l.file.Close()
+}
+
+// Lock takes the lock of the current session. If another process holds it,
+// Lock fails with ErrSessionBusy, or with wait retries until the lock is
+// free or ctx is done.
+func (s *Session) Lock(ctx context.Context, wait bool) (*SessionLock, error) {
+	notified := false
+	for {
+		lock, err := tryLock(s.LogFile)
+		if !errors.Is(err, ErrSessionBusy) {
+			return lock, err
+		}
+		if !wait {
+			return nil, fmt.Errorf("%w: %s is in use by another saa process", ErrSessionBusy, filepath.Base(s.LogFile))
+		}
+		if !notified {
+			fmt.Fprintf(os.Stderr, "Session %s is busy, waiting...\n", filepath.Base(s.LogFile))
+			notified = true
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// sessionBusy reports whether another process holds the lock of logFile.
+func sessionBusy(logFile string) bool {
+	file, err := os.Open(lockPath(logFile))
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	if err := unix.Flock(int(file.Fd()), unix.LOCK_SH|unix.LOCK_NB); err != nil {
+		return errors.Is(err, unix.EWOULDBLOCK)
+	}
+	unix.Flock(int(file.Fd()), unix.LOCK_UN)
+	return false
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it over path, so readers never see a partly written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionLock(t *testing.T) {
+	session := newTestSession(t, "hello")
+
+	lock, err := session.Lock(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if !sessionBusy(session.LogFile) {
+		t.Errorf("sessionBusy = false while locked")
+	}
+	if _, err := session.Lock(context.Background(), false); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("second Lock = %v, want ErrSessionBusy", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockPollInterval)
+	defer cancel()
+	if _, err := session.Lock(ctx, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting Lock = %v, want the deadline", err)
+	}
+
+	first := lock
+	go func() {
+		time.Sleep(lockPollInterval)
+		first.Unlock()
+	}()
+	lock, err = session.Lock(context.Background(), true)
+	if err != nil {
+		t.Fatalf("waiting Lock failed: %v", err)
+	}
+	lock.Unlock()
+	if sessionBusy(session.LogFile) {
+		t.Errorf("sessionBusy = true after Unlock")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "current.json")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if string(data) != "new" {
+		t.Errorf("content = %q, want %q", data, "new")
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != 0644 {
+		t.Errorf("mode = %v, want 0644", fi.Mode().Perm())
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
+
+func TestLoadPinnedSession(t *testing.T) {
+	session := newTestSession(t, "pinned")
+	pinned := filepath.Base(session.LogFile)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	current := filepath.Base(session.LogFile)
+
+	session.Config.Settings.Session = sessionID(pinned)
+	if err := session.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if filepath.Base(session.LogFile) != pinned || len(session.Messages) != 3 {
+		t.Errorf("loaded %s with %d messages, want %s", filepath.Base(session.LogFile), len(session.Messages), pinned)
+	}
+	if got, _ := session.GetCurrentLogFile(); got != current {
+		t.Errorf("current pointer moved to %s", got)
+	}
+}
+
+func TestRunBusySession(t *testing.T) {
+	session := newTestSession(t)
+	session.Config.ProjectRoot = filepath.Dir(session.Config.SaaDir)
+	lock, err := session.Lock(context.Background(), false)
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	defer lock.Unlock()
+
+	agent := NewAgent(session.Config, session)
+	if err := agent.Run(context.Background(), "hello"); !errors.Is(err, ErrSessionBusy) {
+		t.Fatalf("Run = %v, want ErrSessionBusy", err)
+	}
+	if len(session.Messages) != 1 {
+		t.Errorf("Run added to a busy session: %d messages", len(session.Messages))
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,8 +17,10 @@ import (
 var (
 	apiKeyOverride     string
 	apiURLOverride     string
+	providerOverride   string
 	modelOverride      string
 	sessionDirOverride string
+	sessionOverride    string
 	showToolCall       bool
 	showToolResult     bool
 	showReasoning      bool
@@ -49,12 +52,39 @@ func (b *toggleBool) IsBoolFlag() bool {
 	return true
 }
 
+// applyDisplayFlags overrides the display settings with the flags given on
+// the command line.
+func applyDisplayFlags(cmd *cobra.Command, config *Config) {
+	if cmd.Flags().Changed("show-tool-call") {
+		config.Settings.ShowToolCall = showToolCall
+	}
+	if cmd.Flags().Changed("show-tool-result") {
+		config.Settings.ShowToolResult = showToolResult
+	}
+	if cmd.Flags().Changed("show-reasoning") {
+		config.Settings.ShowReasoning = showReasoning
+	}
+	if cmd.Flags().Changed("verbose") {
+		config.Settings.Verbose = verbose
+	}
+}
+
+// ExitCancelled is the exit code when a run is interrupted by a signal.
+const ExitCancelled = 130
+
 func main() {
-	sigChan := make(chan os.Signal, 1)
+	// The first signal cancels the running task so that commands are killed
+	// and the session is left consistent; a second one exits immediately.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigChan
-		os.Exit(0)
+		fmt.Fprintln(os.Stderr, "\nInterrupted. Press Ctrl-C again to force exit.")
+		cancel()
+		<-sigChan
+		os.Exit(ExitCancelled)
 	}()
 
 	rootCmd := &cobra.Command{
@@ -62,12 +92,20 @@ func main() {
 		Short:         "SAA: Single Action Agent",
 		SilenceErrors: true,
 		SilenceUsage:  true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+				return runChat(cmd)
+			}
+			return cmd.Help()
+		},
 	}
 
-	rootCmd.PersistentFlags().StringVar(&apiKeyOverride, "api-key", "", "OpenAI API Key")
-	rootCmd.PersistentFlags().StringVar(&apiURLOverride, "api-url", "", "OpenAI Base URL")
+	rootCmd.PersistentFlags().StringVar(&apiKeyOverride, "api-key", "", "API key")
+	rootCmd.PersistentFlags().StringVar(&apiURLOverride, "api-url", "", "API base URL")
+	rootCmd.PersistentFlags().StringVar(&providerOverride, "provider", "", "API provider: openai, anthropic or ollama")
 	rootCmd.PersistentFlags().StringVar(&modelOverride, "model", "", "Model name")
 	rootCmd.PersistentFlags().StringVar(&sessionDirOverride, "session-dir", "", "Directly specify the session directory")
+	rootCmd.PersistentFlags().StringVarP(&sessionOverride, "session", "s", "", "Use this session (file, title, tag or id prefix) instead of the current one")
 	rootCmd.PersistentFlags().Var(&toggleBool{&showToolCall}, "show-tool-call", "Show agent's tool calls (bash commands)")
 	rootCmd.PersistentFlags().Var(&toggleBool{&showToolResult}, "show-tool-result", "Show results of tool calls")
 	rootCmd.PersistentFlags().Var(&toggleBool{&showReasoning}, "show-reasoning", "Show agent's reasoning content")
@@ -80,8 +118,10 @@ func main() {
 
 	viper.BindPFlag("api_key", rootCmd.PersistentFlags().Lookup("api-key"))
 	viper.BindPFlag("api_url", rootCmd.PersistentFlags().Lookup("api-url"))
+	viper.BindPFlag("provider", rootCmd.PersistentFlags().Lookup("provider"))
 	viper.BindPFlag("model", rootCmd.PersistentFlags().Lookup("model"))
 	viper.BindPFlag("session_dir", rootCmd.PersistentFlags().Lookup("session-dir"))
+	viper.BindPFlag("session", rootCmd.PersistentFlags().Lookup("session"))
 
 	initCmd := &cobra.Command{
 		Use:   "init [directory]",
@@ -119,7 +159,6 @@ func main() {
 			if err := session.NewSession(); err != nil {
 				return err
 			}
-
 			return nil
 		},
 	}
@@ -146,18 +185,7 @@ func main() {
 				return fmt.Errorf(".saa directory not found. Run 'saa init' first")
 			}
 
-			if cmd.Flags().Changed("show-tool-call") {
-				config.Settings.ShowToolCall = showToolCall
-			}
-			if cmd.Flags().Changed("show-tool-result") {
-				config.Settings.ShowToolResult = showToolResult
-			}
-			if cmd.Flags().Changed("show-reasoning") {
-				config.Settings.ShowReasoning = showReasoning
-			}
-			if cmd.Flags().Changed("verbose") {
-				config.Settings.Verbose = verbose
-			}
+			applyDisplayFlags(cmd, config)
 
 			if err := config.SaveConfig(); err != nil {
 				return err
@@ -181,6 +209,7 @@ func main() {
 			if err := session.NewSession(); err != nil {
 				return err
 			}
+			session.autoPrune(os.Stderr)
 
 			return nil
 		},
@@ -202,10 +231,18 @@ func main() {
 		},
 	}
 
-	rootCmd.AddCommand(initCmd, configCmd, NewExecCmd(), newCmd, NewSessionCmd(), whereCmd)
+	rootCmd.AddCommand(initCmd, configCmd, NewExecCmd(), NewChatCmd(), newCmd, NewSessionCmd(), NewDiffCmd(), NewUndoCmd(), NewServeCmd(), whereCmd)
 
-	if err := rootCmd.Execute(); err != nil {
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		if ctx.Err() != nil {
+			fmt.Fprintln(os.Stderr, "Cancelled.")
+			os.Exit(ExitCancelled)
+		}
 		fmt.Fprintln(os.Stderr, err)
+		var budgetErr *BudgetError
+		if errors.As(err, &budgetErr) {
+			os.Exit(ExitBudget)
+		}
 		os.Exit(1)
 	}
 }
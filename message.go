@@ -0,0 +1,55 @@
+package main
+
+import "encoding/json"
+
+// Message roles.
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+	RoleTool      = "tool"
+)
+
+// ToolTypeFunction is the only type of tool call.
+const ToolTypeFunction = "function"
+
+// Message is a chat message as stored in session files. Its JSON is the
+// OpenAI chat format that sessions have always used; providers with another
+// format convert to and from it, so a session can move between providers.
+// Model records which model wrote an assistant message and is not sent.
+type Message struct {
+	Role             string     `json:"role"`
+	Content          string     `json:"content,omitempty"`
+	ReasoningContent string     `json:"reasoning_content,omitempty"`
+	ToolCalls        []ToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string     `json:"tool_call_id,omitempty"`
+	Model            string     `json:"model,omitempty"`
+}
+
+// ToolCall is a call of a tool by the model. Arguments is a JSON object.
+type ToolCall struct {
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type"`
+	Function FunctionCall `json:"function"`
+}
+
+type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// Tool describes a tool offered to the model. Parameters is a JSON schema.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  json.RawMessage
+}
+
+// Usage is the token usage of one request.
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	CachedTokens     int
+	ReasoningTokens  int
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+)
+
+// Values of the auto_title setting.
+const (
+	AutoTitlePrompt = "prompt"
+	AutoTitleModel  = "model"
+	AutoTitleOff    = "off"
+)
+
+const (
+	maxTitleLength       = 60
+	maxFirstPromptLength = 200
+)
+
+const titlePrompt = `Write a short title (at most eight words) for a task given to an autonomous agent.
+Reply with the title only, without quotes or a trailing period.`
+
+// SessionMeta describes a session. It is kept in a sidecar next to the
+// session file; Title and Tags are set by the user or the auto title, the
+// rest is derived from the session file.
+type SessionMeta struct {
+	Title       string    `json:"title,omitempty"`
+	Tags        []string  `json:"tags,omitempty"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+	Model       string    `json:"model,omitempty"`
+	Messages    int       `json:"messages"`
+	FirstPrompt string    `json:"first_prompt,omitempty"`
+}
+
+// metaPath returns the sidecar holding the metadata of a session file.
+func metaPath(logFile string) string {
+	return strings.TrimSuffix(logFile, ".jsonl") + ".meta.json"
+}
+
+// ReadMeta returns the metadata of a session file. Sessions without a
+// sidecar get metadata derived from the file alone.
+func ReadMeta(logFile string) (SessionMeta, error) {
+	var meta SessionMeta
+	data, err := os.ReadFile(metaPath(logFile))
+	if err == nil {
+		if err := json.Unmarshal(data, &meta); err != nil {
+			return meta, fmt.Errorf("invalid metadata for %s: %w", filepath.Base(logFile), err)
+		}
+		return meta, nil
+	}
+	if !os.IsNotExist(err) {
+		return meta, err
+	}
+	return meta, deriveMeta(logFile, &meta)
+}
+
+func writeMeta(logFile string, meta SessionMeta) error {
+	data, err := json.MarshalIndent(meta, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath(logFile), data, 0644)
+}
+
+// deriveMeta fills in the fields of meta that come from the session file.
+func deriveMeta(logFile string, meta *SessionMeta) error {
+	fi, err := os.Stat(logFile)
+	if err != nil {
+		return err
+	}
+	meta.Updated = fi.ModTime()
+	meta.Created = fi.ModTime()
+	if t, ok := sessionTime(filepath.Base(logFile)); ok {
+		meta.Created = t
+	}
+
+	meta.Messages = 0
+	meta.FirstPrompt = ""
+	err = scanSessionFile(logFile, func(typ string, line []byte) error {
+		if typ != "" {
+			return nil
+		}
+		msg, err := decodeMessage(line)
+		if err != nil {
+			return nil
+		}
+		meta.Messages++
+		if msg.Role == RoleUser && meta.FirstPrompt == "" {
+			meta.FirstPrompt = truncateText(msg.Content, maxFirstPromptLength)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return scanUsage(logFile, func(ev UsageEvent) {
+		if ev.Model != "" {
+			meta.Model = ev.Model
+		}
+	})
+}
+
+// UpdateMeta refreshes the metadata sidecar of the current session.
+func (s *Session) UpdateMeta() error {
+	return refreshMeta(s.LogFile, s.Config.Settings)
+}
+
+// refreshMeta updates the metadata sidecar of logFile from the file, and
+// sets a title from the first prompt if it has none yet.
+func refreshMeta(logFile string, settings Settings) error {
+	meta, err := ReadMeta(logFile)
+	if err != nil {
+		return err
+	}
+	if err := deriveMeta(logFile, &meta); err != nil {
+		return err
+	}
+	if meta.Model == "" {
+		meta.Model = settings.Model
+	}
+	if meta.Title == "" && settings.AutoTitle != AutoTitleOff && settings.AutoTitle != AutoTitleModel {
+		meta.Title = titleFromPrompt(meta.FirstPrompt)
+	}
+	return writeMeta(logFile, meta)
+}
+
+// SetTitle sets the title of the session file logFile.
+func SetTitle(logFile, title string) error {
+	return editMeta(logFile, func(meta *SessionMeta) {
+		meta.Title = strings.TrimSpace(title)
+	})
+}
+
+// SetTags adds and removes tags of the session file logFile.
+func SetTags(logFile string, add, remove []string) error {
+	return editMeta(logFile, func(meta *SessionMeta) {
+		for _, tag := range add {
+			if tag = strings.TrimSpace(tag); tag != "" && !slices.Contains(meta.Tags, tag) {
+				meta.Tags = append(meta.Tags, tag)
+			}
+		}
+		meta.Tags = slices.DeleteFunc(meta.Tags, func(tag string) bool {
+			return slices.Contains(remove, tag)
+		})
+	})
+}
+
+func editMeta(logFile string, edit func(meta *SessionMeta)) error {
+	meta, err := ReadMeta(logFile)
+	if err != nil {
+		return err
+	}
+	edit(&meta)
+	return writeMeta(logFile, meta)
+}
+
+// titleFromPrompt makes a title from the first line of a prompt.
+func titleFromPrompt(prompt string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	return truncateText(strings.Join(strings.Fields(line), " "), maxTitleLength)
+}
+
+// truncateText shortens s to at most limit bytes, at a word boundary when
+// possible, and marks the cut with an ellipsis.
+func truncateText(s string, limit int) string {
+	s = strings.TrimSpace(s)
+	if len(s) <= limit {
+		return s
+	}
+	cut := limit - len("...")
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	if i := strings.LastIndexAny(s[:cut], " \n\t"); i > limit/2 {
+		cut = i
+	}
+	return strings.TrimSpace(s[:cut]) + "..."
+}
+
+// maybeTitle asks the model for a title when auto_title is "model" and the
+// session has none yet. Failures only print a notice.
+func (a *Agent) maybeTitle(ctx context.Context) {
+	if a.Config.Settings.AutoTitle != AutoTitleModel {
+		return
+	}
+	meta, err := ReadMeta(a.Session.LogFile)
+	if err != nil || meta.Title != "" || meta.FirstPrompt == "" {
+		return
+	}
+
+	resp, latency, err := a.complete(ctx, CompletionRequest{
+		Messages: []Message{
+			{Role: RoleSystem, Content: titlePrompt},
+			{Role: RoleUser, Content: meta.FirstPrompt},
+		},
+	}, PurposeTitle, display{})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate a session title: %v\n", err)
+		return
+	}
+	if err := a.recordUsage(resp.Model, resp.Usage, latency, "title"); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to record usage of the title request: %v\n", err)
+	}
+
+	title := strings.Trim(strings.TrimSpace(resp.Message.Content), `"'.`)
+	if title == "" {
+		title = titleFromPrompt(meta.FirstPrompt)
+	}
+	if err := SetTitle(a.Session.LogFile, truncateText(title, maxTitleLength)); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save the session title: %v\n", err)
+	}
+}
+
+// sessionID returns the random part of a session file name.
+func sessionID(name string) string {
+	name = strings.TrimSuffix(name, ".jsonl")
+	if _, id, ok := strings.Cut(name, "_"); ok {
+		return id
+	}
+	return name
+}
+
+// Resolve finds a session file by file name, title, unique prefix of its
+// file name or id, or tag. A tag selects the most recently updated session
+// carrying it.
+func (s *Session) Resolve(ref string) (string, error) {
+	if ref == "" {
+		return "", fmt.Errorf("no session given")
+	}
+	files, err := s.List()
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(ref)
+	for _, f := range files {
+		if f == name || f == name+".jsonl" {
+			return f, nil
+		}
+	}
+
+	metas := make(map[string]SessionMeta, len(files))
+	for _, f := range files {
+		meta, err := ReadMeta(filepath.Join(s.SessionDir, f))
+		if err == nil {
+			metas[f] = meta
+		}
+	}
+
+	var matches []string
+	for _, f := range files {
+		if m, ok := metas[f]; ok && m.Title != "" && strings.EqualFold(m.Title, ref) {
+			matches = append(matches, f)
+		}
+	}
+	if len(matches) == 0 {
+		for _, f := range files {
+			if strings.HasPrefix(f, ref) || strings.HasPrefix(sessionID(f), ref) {
+				matches = append(matches, f)
+			}
+		}
+	}
+	switch {
+	case len(matches) == 1:
+		return matches[0], nil
+	case len(matches) > 1:
+		return "", fmt.Errorf("%q matches several sessions: %s", ref, strings.Join(matches, ", "))
+	}
+
+	var newest string
+	for _, f := range files {
+		if m, ok := metas[f]; ok && slices.Contains(m.Tags, ref) {
+			if newest == "" || m.Updated.After(metas[newest].Updated) {
+				newest = f
+			}
+		}
+	}
+	if newest == "" {
+		return "", fmt.Errorf("session not found: %s", ref)
+	}
+	return newest, nil
+}
+
+// sessionEntry is a session file with its metadata, as listed by
+// `saa session list`.
+type sessionEntry struct {
+	File    string `json:"file"`
+	Current bool   `json:"current"`
+	SessionMeta
+}
+
+func printSessionsJSON(w io.Writer, entries []sessionEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
+
+// printSessionsLong writes a table of sessions, marking the current one.
+func printSessionsLong(w io.Writer, entries []sessionEntry) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  ID\tCREATED\tUPDATED\tMSGS\tMODEL\tTAGS\tTITLE")
+	for _, e := range entries {
+		marker := " "
+		if e.Current {
+			marker = "*"
+		}
+		title := e.Title
+		if title == "" {
+			title = titleFromPrompt(e.FirstPrompt)
+		}
+		fmt.Fprintf(tw, "%s %s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			marker, sessionID(e.File), e.Created.Format("2006-01-02 15:04"), e.Updated.Format("2006-01-02 15:04"),
+			e.Messages, e.Model, strings.Join(e.Tags, ","), title)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTitleFromPrompt(t *testing.T) {
+	if got := titleFromPrompt("  fix the   build\nthen run tests"); got != "fix the build" {
+		t.Errorf("titleFromPrompt = %q", got)
+	}
+	long := strings.Repeat("word ", 30)
+	got := titleFromPrompt(long)
+	if len(got) > maxTitleLength || !strings.HasSuffix(got, "...") {
+		t.Errorf("titleFromPrompt did not truncate: %q", got)
+	}
+	if strings.Contains(got, "wo...") {
+		t.Errorf("titleFromPrompt cut a word: %q", got)
+	}
+}
+
+func TestUpdateMeta(t *testing.T) {
+	session := newTestSession(t, "deploy the app", "check the logs")
+	if err := session.UpdateMeta(); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	if _, err := os.Stat(metaPath(session.LogFile)); err != nil {
+		t.Fatalf("metadata sidecar not written: %v", err)
+	}
+
+	meta, err := ReadMeta(session.LogFile)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.Title != "deploy the app" || meta.FirstPrompt != "deploy the app" {
+		t.Errorf("unexpected title %q, first prompt %q", meta.Title, meta.FirstPrompt)
+	}
+	if meta.Messages != 5 {
+		t.Errorf("Messages = %d, want 5", meta.Messages)
+	}
+
+	if err := SetTitle(session.LogFile, "Release"); err != nil {
+		t.Fatalf("SetTitle failed: %v", err)
+	}
+	if err := SetTags(session.LogFile, []string{"prod", "ops"}, nil); err != nil {
+		t.Fatalf("SetTags failed: %v", err)
+	}
+	if err := SetTags(session.LogFile, nil, []string{"ops"}); err != nil {
+		t.Fatalf("SetTags failed: %v", err)
+	}
+	if err := session.UpdateMeta(); err != nil {
+		t.Fatalf("UpdateMeta failed: %v", err)
+	}
+	meta, _ = ReadMeta(session.LogFile)
+	if meta.Title != "Release" || len(meta.Tags) != 1 || meta.Tags[0] != "prod" {
+		t.Errorf("title or tags lost: %+v", meta)
+	}
+}
+
+func TestReadMetaWithoutSidecar(t *testing.T) {
+	session := newTestSession(t, "hello")
+	os.Remove(metaPath(session.LogFile))
+
+	meta, err := ReadMeta(session.LogFile)
+	if err != nil {
+		t.Fatalf("ReadMeta failed: %v", err)
+	}
+	if meta.FirstPrompt != "hello" || meta.Messages != 3 || meta.Created.IsZero() {
+		t.Errorf("unexpected derived metadata: %+v", meta)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	session := newTestSession(t, "first")
+	first := filepath.Base(session.LogFile)
+	SetTitle(session.LogFile, "Database migration")
+	SetTags(session.LogFile, []string{"db"}, nil)
+
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	second := filepath.Base(session.LogFile)
+	SetTags(session.LogFile, []string{"db"}, nil)
+
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{first, first},
+		{strings.TrimSuffix(first, ".jsonl"), first},
+		{"database MIGRATION", first},
+		{sessionID(second)[:6], second},
+		{"db", second},
+	}
+	for _, tt := range tests {
+		got, err := session.Resolve(tt.ref)
+		if err != nil || got != tt.want {
+			t.Errorf("Resolve(%q) = %q, %v; want %q", tt.ref, got, err, tt.want)
+		}
+	}
+
+	if _, err := session.Resolve(first[:4]); err == nil {
+		t.Errorf("Resolve of an ambiguous prefix succeeded")
+	}
+	if _, err := session.Resolve("nothing"); err == nil {
+		t.Errorf("Resolve of an unknown session succeeded")
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Types of RunEvent.
+const (
+	RunStart          = "start"
+	RunReasoning      = "reasoning"
+	RunReasoningDelta = "reasoning_delta"
+	RunMessage        = "message"
+	RunMessageDelta   = "message_delta"
+	RunToolCall       = "tool_call"
+	RunToolResult     = "tool_result"
+	RunUsage          = "usage"
+	RunError          = "error"
+	RunFinished       = "finished"
+)
+
+// Reasons of a RunFinished event. Budget stops use the Stop* constants.
+const (
+	FinishDone      = "done"
+	FinishCancelled = "cancelled"
+	FinishError     = "error"
+)
+
+// RunEvent is one entry of the structured output of a run, as written by
+// `saa exec --output jsonl`. Fields not used by a type are omitted.
+type RunEvent struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Session string    `json:"session,omitempty"`
+	Prompt  string    `json:"prompt,omitempty"`
+	Content string    `json:"content,omitempty"`
+
+	// Tool calls and results.
+	ID        string   `json:"id,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Command   string   `json:"command,omitempty"`
+	ExitCode  *int     `json:"exit_code,omitempty"`
+	Truncated []string `json:"truncated,omitempty"`
+
+	Usage  *UsageEvent `json:"usage,omitempty"`
+	Error  string      `json:"error,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+// emit passes ev to the event handler of the agent, if any.
+func (a *Agent) emit(ev RunEvent) {
+	if a.Events == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	a.Events(ev)
+}
+
+// toolResultEvent describes a tool result. exitCode is nil for commands
+// that did not run.
+func toolResultEvent(toolCallID, result string, exitCode *int) RunEvent {
+	ev := RunEvent{Type: RunToolResult, ID: toolCallID, Content: result, ExitCode: exitCode}
+	for _, m := range truncatedLogRe.FindAllStringSubmatch(result, -1) {
+		ev.Truncated = append(ev.Truncated, m[2])
+	}
+	return ev
+}
+
+// finishEvent describes how a run ended.
+func finishEvent(err error) RunEvent {
+	var budget *BudgetError
+	switch {
+	case err == nil:
+		return RunEvent{Type: RunFinished, Reason: FinishDone}
+	case errors.As(err, &budget):
+		return RunEvent{Type: RunFinished, Reason: budget.Reason}
+	case errors.Is(err, context.Canceled):
+		return RunEvent{Type: RunFinished, Reason: FinishCancelled}
+	}
+	return RunEvent{Type: RunFinished, Reason: FinishError, Error: err.Error()}
+}
+
+// jsonlWriter writes events as JSON lines. It is safe for concurrent use.
+type jsonlWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func newJSONLWriter(w io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(w)}
+}
+
+func (w *jsonlWriter) write(ev RunEvent) {
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(ev)
+}
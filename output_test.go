@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunEvents(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests == 1 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"[TOOL] not a tool","tool_calls":[
+				{"id":"1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"seq 1 100\"}"}}
+			]}}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"done","reasoning_content":"thinking"}}]}`)
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	config := &Config{
+		ProjectRoot: root,
+		SaaDir:      filepath.Join(root, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test", MaxStdout: 10},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	events := newJSONLWriter(&out)
+	agent := NewAgent(config, session)
+	agent.Events = events.write
+	err := agent.Run(context.Background(), "count")
+	events.write(finishEvent(err))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	var got []RunEvent
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var ev RunEvent
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		got = append(got, ev)
+	}
+
+	var types []string
+	for _, ev := range got {
+		types = append(types, ev.Type)
+	}
+	want := []string{RunStart, RunUsage, RunMessage, RunToolCall, RunToolResult, RunUsage, RunReasoning, RunMessage, RunFinished}
+	if strings.Join(types, " ") != strings.Join(want, " ") {
+		t.Fatalf("event types = %v, want %v", types, want)
+	}
+
+	if got[0].Session != filepath.Base(session.LogFile) || got[0].Prompt != "count" {
+		t.Errorf("unexpected start event: %+v", got[0])
+	}
+	if got[2].Content != "[TOOL] not a tool" {
+		t.Errorf("unexpected message event: %+v", got[2])
+	}
+	if got[3].ID != "1" || got[3].Command != "seq 1 100" {
+		t.Errorf("unexpected tool call event: %+v", got[3])
+	}
+	result := got[4]
+	if result.ID != "1" || result.ExitCode == nil || *result.ExitCode != 0 || len(result.Truncated) != 1 {
+		t.Errorf("unexpected tool result event: %+v", result)
+	}
+	if got[1].Usage == nil || got[1].Usage.TotalTokens != 15 {
+		t.Errorf("unexpected usage event: %+v", got[1])
+	}
+	if got[8].Reason != FinishDone {
+		t.Errorf("unexpected finished event: %+v", got[8])
+	}
+}
+
+func TestFinishEvent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, FinishDone},
+		{context.Canceled, FinishCancelled},
+		{fmt.Errorf("run: %w", &BudgetError{Reason: StopMaxTurns}), StopMaxTurns},
+		{fmt.Errorf("boom"), FinishError},
+	}
+	for _, tt := range tests {
+		if got := finishEvent(tt.err); got.Type != RunFinished || got.Reason != tt.want {
+			t.Errorf("finishEvent(%v) = %+v, want reason %s", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// readOnlyTools is tools with a read_only flag, offered when the commands
+// of a message may run in parallel.
+var readOnlyTools = []Tool{
+	{
+		Name:        "bash",
+		Description: "Execute a bash command and get the output.",
+		Parameters: json.RawMessage(`{
+			"type": "object",
+			"properties": {
+				"command": {"type": "string", "description": "The command to run."},
+				"timeout": {"type": "integer", "description": "The timeout in seconds. Default is no timeout."},
+				"read_only": {"type": "boolean", "description": "True if the command only reads, so it can run at the same time as the other read-only commands of this message."}
+			},
+			"required": ["command"]
+		}`),
+	},
+}
+
+// tools returns the tools offered to the model.
+func (a *Agent) tools() []Tool {
+	if a.parallelLimit() > 1 {
+		return readOnlyTools
+	}
+	return tools
+}
+
+// oneToolConstraint is the line of SystemPrompt that parallelPrompt
+// replaces.
+const oneToolConstraint = "- You can only execute one tool at a time."
+
+const parallelPrompt = `- You can call several tools in one message. Commands that only read (such as cat, grep, ls or git log) run at the same time, and the others run one by one in order, so batch independent reads and keep steps that depend on each other in separate messages.`
+
+// parallelToolRequest tells the model in the system prompt that it may
+// batch read-only calls.
+func parallelToolRequest(req CompletionRequest) CompletionRequest {
+	msgs := slices.Clone(req.Messages)
+	for i, m := range msgs {
+		if m.Role != RoleSystem {
+			continue
+		}
+		if strings.Contains(m.Content, oneToolConstraint) {
+			m.Content = strings.Replace(m.Content, oneToolConstraint, parallelPrompt, 1)
+		} else {
+			m.Content += "\n\n" + parallelPrompt
+		}
+		msgs[i] = m
+	}
+	req.Messages = msgs
+	return req
+}
+
+// parallelLimit returns how many commands may run at once. A persistent
+// shell runs one command at a time.
+func (a *Agent) parallelLimit() int {
+	if a.Config.Settings.PersistentShell {
+		return 1
+	}
+	return max(a.Config.Settings.ParallelTools, 1)
+}
+
+// toolJob is a tool call of a message. Calls that need no command to run
+// already have their result.
+type toolJob struct {
+	tc       ToolCall
+	command  string
+	edited   bool
+	timeout  time.Duration
+	run      bool
+	show     bool
+	result   string
+	exitCode *int
+}
+
+// runToolCalls executes the tool calls of a message and records their
+// results in order. Consecutive calls that only read run in parallel up to
+// parallel_tools at once; their results are printed when all are done.
+func (a *Agent) runToolCalls(ctx, runCtx context.Context, calls []ToolCall, show display) error {
+	var batch []*toolJob
+	flush := func() error {
+		err := a.runBatch(ctx, runCtx, batch, show)
+		batch = nil
+		return err
+	}
+
+	for _, tc := range calls {
+		if tc.Function.Name != "bash" {
+			a.emit(RunEvent{Type: RunToolCall, ID: tc.ID, Name: tc.Function.Name, Command: toolCallCommand(tc)})
+			// Every tool call needs a result, or the next request is rejected.
+			batch = append(batch, &toolJob{tc: tc, result: fmt.Sprintf("Unknown tool: %s", tc.Function.Name)})
+			continue
+		}
+
+		if runCtx.Err() != nil {
+			batch = append(batch, &toolJob{tc: tc, result: interruptedResult(ctx)})
+			continue
+		}
+
+		var args struct {
+			Command  string `json:"command"`
+			Timeout  int    `json:"timeout"`
+			ReadOnly *bool  `json:"read_only"`
+		}
+		if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+			a.emit(RunEvent{Type: RunToolCall, ID: tc.ID, Name: tc.Function.Name, Command: tc.Function.Arguments})
+			batch = append(batch, &toolJob{tc: tc, show: true, result: fmt.Sprintf("Invalid tool arguments: %v", err)})
+			continue
+		}
+
+		parallel := a.parallelLimit() > 1 && parallelSafe(args.Command, args.ReadOnly)
+		if !parallel {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		if show.call {
+			fmt.Printf("[TOOL] %s\n", args.Command)
+		}
+		a.emit(RunEvent{Type: RunToolCall, ID: tc.ID, Name: tc.Function.Name, Command: args.Command})
+
+		command, refusal, err := a.authorize(tc.ID, args.Command)
+		if err != nil {
+			return err
+		}
+		job := &toolJob{tc: tc, show: true, result: refusal}
+		if refusal == "" {
+			job.command = command
+			job.edited = command != args.Command
+			job.timeout = time.Duration(args.Timeout) * time.Second
+			job.run = true
+		}
+		// The user may have edited the command into one that writes.
+		if parallel && job.edited && !parallelSafe(command, args.ReadOnly) {
+			if err := flush(); err != nil {
+				return err
+			}
+			parallel = false
+		}
+
+		batch = append(batch, job)
+		if !parallel {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// runBatch runs the commands of jobs at the same time, then prints and
+// records the results in order.
+func (a *Agent) runBatch(ctx, runCtx context.Context, jobs []*toolJob, show display) error {
+	var run []*toolJob
+	for _, job := range jobs {
+		if job.run {
+			run = append(run, job)
+		}
+	}
+
+	var before string
+	if len(run) > 0 {
+		before = a.snapshot()
+	}
+	sem := make(chan struct{}, a.parallelLimit())
+	var wg sync.WaitGroup
+	for _, job := range run {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			result, exitCode, err := a.runTool(runCtx, job.command, job.timeout)
+			if err != nil {
+				result = interruptedResult(ctx)
+			} else if job.edited {
+				result = fmt.Sprintf("Note: the user edited the command before running it:\n%s\n%s", job.command, result)
+			}
+			job.result, job.exitCode = result, exitCode
+		}()
+	}
+	wg.Wait()
+	// Commands run together share their snapshots.
+	for _, job := range run {
+		if err := a.recordSnapshot(job.tc.ID, job.command, before); err != nil {
+			return err
+		}
+	}
+
+	for _, job := range jobs {
+		if show.result && job.show {
+			fmt.Printf("[RESULT]\n%s\n", job.result)
+		}
+		a.countFailure(job.exitCode)
+		if err := a.addToolResult(job.tc.ID, job.result, job.exitCode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	// harmlessRedirectRe matches redirections that write no file.
+	harmlessRedirectRe = regexp.MustCompile(`\d*>&\d+|\d*>\s*/dev/null`)
+	commandSepRe       = regexp.MustCompile(`\|\||&&|[|;&\n]`)
+	envAssignRe        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// readCommands only read files, whatever their arguments, except for the
+// options rejected by writesFiles.
+var readCommands = []string{
+	"cat", "cd", "cmp", "cut", "df", "diff", "du", "echo", "egrep", "fgrep",
+	"file", "find", "grep", "head", "jq", "ls", "nl", "printf", "pwd", "rg",
+	"sed", "sleep", "sort", "stat", "tail", "test", "tr", "tree", "true", "uniq", "wc",
+	"which",
+}
+
+// readGitCommands are the git subcommands that only read.
+var readGitCommands = []string{"blame", "diff", "grep", "log", "ls-files", "rev-parse", "show", "status"}
+
+// writeCommands change files.
+var writeCommands = []string{"chmod", "chown", "cp", "dd", "install", "ln", "mkdir", "mv", "patch", "rm", "rmdir", "tee", "touch", "truncate"}
+
+// parallelSafe reports whether a command may run alongside others: only
+// commands known to read qualify. The model's read_only flag can rule a
+// command out but never in.
+func parallelSafe(command string, readOnly *bool) bool {
+	if readOnly != nil && !*readOnly {
+		return false
+	}
+	segments, ok := commandSegments(command)
+	if !ok || writesFiles(segments) {
+		return false
+	}
+	for _, words := range segments {
+		if words[0] != "git" && !slices.Contains(readCommands, words[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// commandSegments splits a command line into the words of its simple
+// commands, without leading variable assignments. It reports false for
+// command lines it cannot judge: with redirections to files, command
+// substitutions or subshells.
+func commandSegments(command string) ([][]string, bool) {
+	command = harmlessRedirectRe.ReplaceAllString(command, " ")
+	if strings.ContainsAny(command, ">`(") {
+		return nil, false
+	}
+	var segments [][]string
+	for _, part := range commandSepRe.Split(command, -1) {
+		words := strings.Fields(part)
+		for len(words) > 0 && envAssignRe.MatchString(words[0]) {
+			words = words[1:]
+		}
+		if len(words) > 0 {
+			segments = append(segments, words)
+		}
+	}
+	return segments, len(segments) > 0
+}
+
+// writesFiles reports whether any of the commands looks like it writes.
+func writesFiles(segments [][]string) bool {
+	for _, words := range segments {
+		args := words[1:]
+		switch {
+		case slices.Contains(writeCommands, words[0]):
+			return true
+		case words[0] == "git" && (len(words) < 2 || !slices.Contains(readGitCommands, words[1])):
+			return true
+		case words[0] == "sed" && sedWrites(args):
+			return true
+		}
+		for _, w := range args {
+			switch {
+			case words[0] == "sort" && (strings.HasPrefix(w, "--output") || hasShortOption(w, 'o', "kStT")),
+				words[0] == "tree" && hasShortOption(w, 'o', "HILPT"),
+				words[0] == "find" && slices.Contains([]string{"-delete", "-exec", "-execdir", "-ok", "-okdir", "-fprint", "-fprintf", "-fls"}, w):
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sedWrites reports whether sed with args may write files: in place, with
+// a script file it cannot see, or through the w, W or e commands of its
+// script. Scripts are not parsed, so any of those letters counts.
+func sedWrites(args []string) bool {
+	var scripts, operands []string
+	nextScript := false
+	for _, w := range args {
+		switch {
+		case nextScript:
+			scripts = append(scripts, w)
+			nextScript = false
+		case strings.HasPrefix(w, "--in-place"), strings.HasPrefix(w, "--file"):
+			return true
+		case w == "--expression":
+			nextScript = true
+		case strings.HasPrefix(w, "--expression="):
+			scripts = append(scripts, strings.TrimPrefix(w, "--expression="))
+		case strings.HasPrefix(w, "--"):
+		case len(w) > 1 && w[0] == '-':
+			if hasShortOption(w, 'i', "el") || hasShortOption(w, 'f', "el") {
+				return true
+			}
+			if i := strings.IndexByte(w, 'e'); i > 0 && !hasShortOption(w, 'l', "e") {
+				if script := w[i+1:]; script != "" {
+					scripts = append(scripts, script)
+				} else {
+					nextScript = true
+				}
+			}
+		default:
+			operands = append(operands, w)
+		}
+	}
+	if len(scripts) == 0 && len(operands) > 0 {
+		scripts = operands[:1]
+	}
+	for _, script := range scripts {
+		if strings.ContainsAny(script, "wWe") {
+			return true
+		}
+	}
+	return nextScript
+}
+
+// hasShortOption reports whether word is a bundle of short options, like
+// -uo, that contains letter before any of argLetters, whose argument takes
+// the rest of the word.
+func hasShortOption(word string, letter byte, argLetters string) bool {
+	if len(word) < 2 || word[0] != '-' || word[1] == '-' {
+		return false
+	}
+	for i := 1; i < len(word); i++ {
+		if word[i] == letter {
+			return true
+		}
+		if strings.IndexByte(argLetters, word[i]) >= 0 {
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestParallelSafe(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		command  string
+		readOnly *bool
+		want     bool
+	}{
+		{"cat a.txt", nil, true},
+		{"grep -rn foo src 2>/dev/null | head -20", nil, true},
+		{"cd src && ls -la 2>&1", nil, true},
+		{"git log --oneline -5", nil, true},
+		{"LC_ALL=C sort -u names.txt", nil, true},
+		{"go test ./...", nil, false},
+		{"go test ./...", &yes, false},
+		{"cat a.txt", &no, false},
+		{"echo hi > a.txt", &yes, false},
+		{"sed -i s/a/b/ a.txt", &yes, false},
+		{"find . -name '*.tmp' -delete", nil, false},
+		{"sort -o out.txt in.txt", &yes, false},
+		{"sort -uo out.txt in.txt", &yes, false},
+		{"sort -u -k2 in.txt", nil, true},
+		{"sed -n 1,20p a.txt", nil, true},
+		{"sed -Ei s/a/b/ a.txt", &yes, false},
+		{"sed -ni 1p a.txt", &yes, false},
+		{"sed 's/a/b/w out' a.txt", &yes, false},
+		{"sed -n -e 1p -e '$W out' a.txt", &yes, false},
+		{"sed -f script.sed a.txt", &yes, false},
+		{"tree -ao out.txt", &yes, false},
+		{"ls && rm -rf build", &yes, false},
+		{"git checkout main", &yes, false},
+		{"cat $(ls)", &yes, false},
+	}
+	for _, tt := range tests {
+		if got := parallelSafe(tt.command, tt.readOnly); got != tt.want {
+			t.Errorf("parallelSafe(%q, %v) = %v, want %v", tt.command, tt.readOnly != nil && *tt.readOnly, got, tt.want)
+		}
+	}
+}
+
+func TestRunParallelTools(t *testing.T) {
+	var requests []openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) > 1 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"done"}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[
+			{"id":"1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"sleep 0.5; echo one\",\"read_only\":true}"}},
+			{"id":"2","type":"function","function":{"name":"bash","arguments":"{\"command\":\"sleep 0.5; echo two\",\"read_only\":true}"}},
+			{"id":"bad","type":"function","function":{"name":"bash","arguments":"{\"command\":"}},
+			{"id":"3","type":"function","function":{"name":"bash","arguments":"{\"command\":\"sleep 0.5; echo three\",\"read_only\":true}"}},
+			{"id":"4","type":"function","function":{"name":"bash","arguments":"{\"command\":\"echo four > four.txt\",\"read_only\":true}"}}
+		]}}]}`)
+	}))
+	defer server.Close()
+
+	tmpDir := t.TempDir()
+	config := &Config{
+		ProjectRoot: tmpDir,
+		SaaDir:      filepath.Join(tmpDir, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test", ParallelTools: 3},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+
+	start := time.Now()
+	if err := agent.Run(context.Background(), "read"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 1200*time.Millisecond {
+		t.Errorf("expected the reads to run in parallel, took %v", elapsed)
+	}
+
+	if params, _ := json.Marshal(requests[0].Tools[0].Function.Parameters); !strings.Contains(string(params), "read_only") {
+		t.Errorf("expected the read_only flag to be offered, got %s", params)
+	}
+	if prompt := requests[0].Messages[0].Content; strings.Contains(prompt, oneToolConstraint) || !strings.Contains(prompt, parallelPrompt) {
+		t.Errorf("expected the system prompt to allow several calls, got %q", prompt)
+	}
+
+	// Results are recorded in the order of the calls, and a call with
+	// broken arguments gets its own result without stopping the others.
+	msgs := session.Messages
+	if len(msgs) != 9 {
+		t.Fatalf("expected 9 messages, got %d", len(msgs))
+	}
+	for i, want := range []struct{ id, content string }{
+		{"1", "STDOUT:\none\n\nSTDERR"},
+		{"2", "STDOUT:\ntwo\n\nSTDERR"},
+		{"bad", "Invalid tool arguments"},
+		{"3", "STDOUT:\nthree\n\nSTDERR"},
+		{"4", "Exit Code: 0"},
+	} {
+		m := msgs[3+i]
+		if m.Role != RoleTool || m.ToolCallID != want.id || !strings.Contains(m.Content, want.content) {
+			t.Errorf("unexpected result %d: %+v", i+1, m)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(tmpDir, "four.txt")); err != nil || string(data) != "four\n" {
+		t.Errorf("expected the write to run, got %q, %v", data, err)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Policy actions, from least to most restrictive.
+const (
+	PolicyAllow = "allow"
+	PolicyAsk   = "ask"
+	PolicyDeny  = "deny"
+)
+
+type PolicySettings struct {
+	Default string       `mapstructure:"default" json:"default,omitempty"`
+	Rules   []PolicyRule `mapstructure:"rules" json:"rules,omitempty"`
+}
+
+// PolicyRule matches a command either by a regular expression on the whole
+// command (Pattern) or by the first word of any pipeline segment (Command).
+type PolicyRule struct {
+	Action  string `mapstructure:"action" json:"action"`
+	Pattern string `mapstructure:"pattern" json:"pattern,omitempty"`
+	Command string `mapstructure:"command" json:"command,omitempty"`
+}
+
+func (r PolicyRule) String() string {
+	if r.Pattern != "" {
+		return fmt.Sprintf("%s pattern %q", r.Action, r.Pattern)
+	}
+	return fmt.Sprintf("%s command %q", r.Action, r.Command)
+}
+
+type Policy struct {
+	def   string
+	rules []compiledRule
+}
+
+type compiledRule struct {
+	PolicyRule
+	re *regexp.Regexp
+}
+
+// PolicyDecision is the outcome of evaluating a command. Rule describes the
+// rule that decided it, or is empty when the default applied.
+type PolicyDecision struct {
+	Action string
+	Rule   string
+}
+
+func NewPolicy(settings PolicySettings) (*Policy, error) {
+	p := &Policy{def: settings.Default}
+	if p.def == "" {
+		p.def = PolicyAllow
+	}
+	if restrictiveness(p.def) < 0 {
+		return nil, fmt.Errorf("invalid policy default: %q", p.def)
+	}
+
+	for _, r := range settings.Rules {
+		if restrictiveness(r.Action) < 0 {
+			return nil, fmt.Errorf("invalid policy action: %q", r.Action)
+		}
+		cr := compiledRule{PolicyRule: r}
+		switch {
+		case r.Pattern != "" && r.Command != "":
+			return nil, fmt.Errorf("policy rule has both pattern and command: %s", r)
+		case r.Pattern != "":
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid policy pattern %q: %w", r.Pattern, err)
+			}
+			cr.re = re
+		case r.Command == "":
+			return nil, fmt.Errorf("policy rule needs a pattern or a command")
+		}
+		p.rules = append(p.rules, cr)
+	}
+	return p, nil
+}
+
+// Active reports whether the policy can do anything but allow.
+func (p *Policy) Active() bool {
+	return len(p.rules) > 0 || p.def != PolicyAllow
+}
+
+// Evaluate decides what to do with command. Pattern rules apply to the whole
+// command and to each pipeline segment, command rules to the segments;
+// every pipeline segment not matched by any rule gets the default. The most
+// restrictive result wins.
+func (p *Policy) Evaluate(command string) PolicyDecision {
+	var decision PolicyDecision
+	consider := func(action, rule string) {
+		if decision.Action == "" || restrictiveness(action) > restrictiveness(decision.Action) {
+			decision = PolicyDecision{Action: action, Rule: rule}
+		}
+	}
+
+	for _, r := range p.rules {
+		if r.re != nil && r.re.MatchString(command) {
+			consider(r.Action, r.String())
+		}
+	}
+
+	for _, segment := range splitPipeline(command) {
+		word := commandWord(segment)
+		if word == "" {
+			continue
+		}
+		segment = strings.TrimSpace(segment)
+		matched := false
+		for _, r := range p.rules {
+			switch {
+			case r.re != nil && r.re.MatchString(segment),
+				r.re == nil && (r.Command == word || r.Command == filepath.Base(word)):
+				matched = true
+				consider(r.Action, r.String())
+			}
+		}
+		if !matched {
+			consider(p.def, "")
+		}
+	}
+
+	if decision.Action == "" {
+		decision.Action = p.def
+	}
+	return decision
+}
+
+func restrictiveness(action string) int {
+	switch action {
+	case PolicyAllow:
+		return 0
+	case PolicyAsk:
+		return 1
+	case PolicyDeny:
+		return 2
+	}
+	return -1
+}
+
+// splitPipeline splits a command line at unquoted |, ||, &&, ;, & and
+// newlines. It is a heuristic, not a shell parser: command substitutions
+// are not inspected, so use pattern rules for those.
+func splitPipeline(command string) []string {
+	var segments []string
+	var cur strings.Builder
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == '\\' && quote != '\'' && i+1 < len(command):
+			cur.WriteByte(c)
+			cur.WriteByte(command[i+1])
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+			cur.WriteByte(c)
+		case c == '\'' || c == '"':
+			quote = c
+			cur.WriteByte(c)
+		case c == '&' && (i > 0 && (command[i-1] == '>' || command[i-1] == '<') || i+1 < len(command) && command[i+1] == '>'):
+			// Redirections like 2>&1 and &>file
+			cur.WriteByte(c)
+		case c == '|' || c == '&' || c == ';' || c == '\n':
+			segments = append(segments, cur.String())
+			cur.Reset()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	segments = append(segments, cur.String())
+	return segments
+}
+
+// wrapperArgs are the options of command wrappers that take an argument.
+var wrapperArgs = map[string][]string{
+	"sudo": {"-C", "-D", "-g", "-h", "-p", "-R", "-r", "-T", "-t", "-U", "-u",
+		"--chdir", "--close-from", "--command-timeout", "--group", "--host", "--other-user", "--prompt", "--role", "--chroot", "--type", "--user"},
+	"env":  {"-C", "-u", "--chdir", "--unset"},
+	"time": {"-f", "-o", "--format", "--output"},
+	"exec": {"-a"},
+}
+
+// commandWord returns the command run by a pipeline segment, skipping
+// variable assignments, grouping, keywords and wrappers like sudo or env.
+// A wrapper whose command cannot be found, as in env -S, is returned
+// itself.
+func commandWord(segment string) string {
+	wrapper, skip := "", false
+	for _, field := range strings.Fields(segment) {
+		field = strings.TrimLeft(field, "({!")
+		switch {
+		case field == "":
+			continue
+		case skip:
+			skip = false
+			continue
+		case wrapper != "" && strings.HasPrefix(field, "-"):
+			if wrapper == "env" && (strings.HasPrefix(field, "--split-string") || !strings.HasPrefix(field, "--") && strings.Contains(field, "S")) {
+				return wrapper
+			}
+			skip = takesArg(wrapperArgs[wrapper], field)
+			continue
+		case strings.ContainsAny(field[:1], "<>"):
+			// A bare operator takes the next field as its target.
+			skip = strings.Trim(field, "<>&") == ""
+			continue
+		case strings.Contains(field, "=") && !strings.HasPrefix(field, "="):
+			continue
+		}
+		switch field {
+		case "sudo", "env", "time", "nohup", "command", "exec":
+			wrapper = field
+			continue
+		case "if", "then", "else", "elif", "while", "until", "do":
+			continue
+		}
+		return strings.Trim(field, `'"`)
+	}
+	return wrapper
+}
+
+// takesArg reports whether option is followed by a separate argument,
+// given the options that take one. Short options may be bundled, as in
+// -Eu; the one taking an argument ends the bundle unless the argument is
+// attached to it.
+func takesArg(options []string, option string) bool {
+	if strings.HasPrefix(option, "--") {
+		return !strings.Contains(option, "=") && slices.Contains(options, option)
+	}
+	for i := 1; i < len(option); i++ {
+		if slices.Contains(options, "-"+option[i:i+1]) {
+			return i == len(option)-1
+		}
+	}
+	return false
+}
+
+// authorize applies the command policy to a tool call and records the
+// decision in the session. It returns the command to run, which the user
+// may have edited, or a refusal to send back to the model instead.
+func (a *Agent) authorize(toolCallID, command string) (string, string, error) {
+	if a.policy == nil || !a.policy.Active() {
+		return command, "", nil
+	}
+
+	d := a.policy.Evaluate(command)
+	ev := PolicyEvent{
+		Type:       EventPolicy,
+		Time:       time.Now(),
+		ToolCallID: toolCallID,
+		Command:    command,
+		Decision:   d.Action,
+		Rule:       d.Rule,
+		By:         "policy",
+	}
+
+	refusal := ""
+	switch d.Action {
+	case PolicyDeny:
+		refusal = fmt.Sprintf("Command denied by policy (%s). Do not retry it; use a different approach or ask the user.", ev.describeRule())
+	case PolicyAsk:
+		switch {
+		case a.approved[command]:
+			ev.Decision = PolicyAllow
+			ev.By = "always"
+		case a.NonInteractive || !isTerminal(os.Stdin):
+			ev.Decision = PolicyDeny
+			ev.By = "non-interactive"
+			refusal = fmt.Sprintf("Command requires approval (%s), but saa is running non-interactively, so it was denied.", ev.describeRule())
+		default:
+			edited, allowed, err := a.askApproval(command)
+			if err != nil {
+				return "", "", err
+			}
+			ev.By = "user"
+			if !allowed {
+				ev.Decision = PolicyDeny
+				refusal = "Command denied by the user."
+			} else {
+				ev.Decision = PolicyAllow
+				if edited != command {
+					ev.Edited = edited
+					command = edited
+				}
+			}
+		}
+	}
+
+	if err := a.Session.AddEvent(ev); err != nil {
+		return "", "", err
+	}
+	if refusal != "" {
+		return "", refusal, nil
+	}
+	return command, "", nil
+}
+
+// askApproval prompts on the terminal until the user gives a valid answer.
+func (a *Agent) askApproval(command string) (string, bool, error) {
+	if a.stdin == nil {
+		a.stdin = bufio.NewReader(os.Stdin)
+	}
+
+	fmt.Fprintf(os.Stderr, "[APPROVE] %s\n", command)
+	for {
+		fmt.Fprint(os.Stderr, "Run this command? [y/N/edit/always] ")
+		line, err := a.stdin.ReadString('\n')
+		if err != nil && line == "" {
+			if errors.Is(err, io.EOF) {
+				return command, false, nil
+			}
+			return "", false, err
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return command, true, nil
+		case "", "n", "no":
+			return command, false, nil
+		case "a", "always":
+			if a.approved == nil {
+				a.approved = map[string]bool{}
+			}
+			a.approved[command] = true
+			return command, true, nil
+		case "e", "edit":
+			fmt.Fprint(os.Stderr, "Command: ")
+			edited, err := a.stdin.ReadString('\n')
+			if err != nil && edited == "" {
+				return command, false, nil
+			}
+			edited = strings.TrimSpace(edited)
+			if edited == "" {
+				return command, false, nil
+			}
+			return edited, true, nil
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitPipeline(t *testing.T) {
+	tests := []struct {
+		command  string
+		expected []string
+	}{
+		{"ls -la", []string{"ls -la"}},
+		{"cat a | grep b && rm c; echo d", []string{"cat a ", " grep b ", "", " rm c", " echo d"}},
+		{`echo "a | b" 'c; d'`, []string{`echo "a | b" 'c; d'`}},
+		{"make 2>&1 | tee log", []string{"make 2>&1 ", " tee log"}},
+		{"sleep 1 &\nwait", []string{"sleep 1 ", "", "wait"}},
+	}
+
+	for _, tt := range tests {
+		got := splitPipeline(tt.command)
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("splitPipeline(%q) = %q, expected %q", tt.command, got, tt.expected)
+		}
+	}
+}
+
+func TestCommandWord(t *testing.T) {
+	tests := map[string]string{
+		"ls -la":                 "ls",
+		" FOO=bar make test":     "make",
+		"sudo -E rm -rf x":       "rm",
+		"(cd sub && ls)":         "cd",
+		"/bin/rm x":              "/bin/rm",
+		"if test -f x":           "test",
+		"> out.txt echo hi":      "echo",
+		"":                       "",
+		"env PATH=/usr/bin curl": "curl",
+		"sudo -u root rm -rf x":  "rm",
+		"sudo -Eu root rm x":     "rm",
+		"sudo -uroot rm x":       "rm",
+		"sudo --user root rm x":  "rm",
+		"sudo --user=root rm x":  "rm",
+		"env -u HOME -C /tmp rm": "rm",
+		"time -f %e rm x":        "rm",
+		"env -S 'rm -rf x'":      "env",
+		"sudo":                   "sudo",
+	}
+
+	for segment, expected := range tests {
+		if got := commandWord(segment); got != expected {
+			t.Errorf("commandWord(%q) = %q, expected %q", segment, got, expected)
+		}
+	}
+}
+
+func TestPolicyEvaluate(t *testing.T) {
+	policy, err := NewPolicy(PolicySettings{
+		Default: PolicyAsk,
+		Rules: []PolicyRule{
+			{Action: PolicyAllow, Command: "ls"},
+			{Action: PolicyAllow, Command: "cat"},
+			{Action: PolicyAllow, Command: "git"},
+			{Action: PolicyAsk, Pattern: `^git\s+push`},
+			{Action: PolicyDeny, Command: "rm"},
+			{Action: PolicyAllow, Pattern: `^make( |$)`},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		action  string
+		rule    string
+	}{
+		{"ls -la", PolicyAllow, `allow command "ls"`},
+		{"cat a | grep b", PolicyAsk, ""},
+		{"ls && /bin/rm -rf x", PolicyDeny, `deny command "rm"`},
+		{"git status", PolicyAllow, `allow command "git"`},
+		{"git push origin main", PolicyAsk, `ask pattern "^git\\s+push"`},
+		{"make test 2>&1", PolicyAllow, `allow pattern "^make( |$)"`},
+		{"make test 2>&1 | tail", PolicyAsk, ""},
+		{"sudo -u root rm -rf x", PolicyDeny, `deny command "rm"`},
+		{"python3 script.py", PolicyAsk, ""},
+	}
+
+	for _, tt := range tests {
+		d := policy.Evaluate(tt.command)
+		if d.Action != tt.action || d.Rule != tt.rule {
+			t.Errorf("Evaluate(%q) = %+v, expected %s (%s)", tt.command, d, tt.action, tt.rule)
+		}
+	}
+
+	// An allow pattern does not lift a stricter default from the segments
+	// it does not match.
+	policy, err = NewPolicy(PolicySettings{
+		Default: PolicyDeny,
+		Rules:   []PolicyRule{{Action: PolicyAllow, Pattern: "^ls"}},
+	})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+	if d := policy.Evaluate("ls; rm -rf /"); d.Action != PolicyDeny || d.Rule != "" {
+		t.Errorf(`Evaluate("ls; rm -rf /") = %+v, expected deny by default`, d)
+	}
+	if d := policy.Evaluate("ls -la"); d.Action != PolicyAllow {
+		t.Errorf(`Evaluate("ls -la") = %+v, expected allow`, d)
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	tests := []PolicySettings{
+		{Default: "maybe"},
+		{Rules: []PolicyRule{{Action: "nope", Command: "ls"}}},
+		{Rules: []PolicyRule{{Action: PolicyDeny}}},
+		{Rules: []PolicyRule{{Action: PolicyDeny, Pattern: "("}}},
+		{Rules: []PolicyRule{{Action: PolicyDeny, Pattern: "x", Command: "x"}}},
+	}
+
+	for _, settings := range tests {
+		if _, err := NewPolicy(settings); err == nil {
+			t.Errorf("expected error for %+v", settings)
+		}
+	}
+
+	policy, err := NewPolicy(PolicySettings{})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+	if policy.Active() {
+		t.Error("expected empty policy to be inactive")
+	}
+	if d := policy.Evaluate("rm -rf /"); d.Action != PolicyAllow {
+		t.Errorf("expected empty policy to allow, got %+v", d)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Providers of the model API.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+)
+
+// CompletionRequest is a request for the next assistant message.
+type CompletionRequest struct {
+	Model    string
+	Messages []Message
+	Tools    []Tool
+}
+
+// Completion is the answer to a CompletionRequest. Model is set by the
+// agent to the model that gave it.
+type Completion struct {
+	Message Message
+	Usage   Usage
+	Model   string
+}
+
+var errEmptyResponse = errors.New("empty response")
+
+// DeltaFunc receives the pieces of a streamed answer as they arrive.
+type DeltaFunc func(reasoning, content string)
+
+// Provider sends requests to a model API. With a non-nil onDelta, the
+// answer is streamed; the returned Completion is complete either way.
+type Provider interface {
+	Complete(ctx context.Context, req CompletionRequest, onDelta DeltaFunc) (Completion, error)
+}
+
+// NewProvider returns the provider named in settings, sending its requests
+// with client.
+func NewProvider(settings Settings, client *http.Client) Provider {
+	baseURL := strings.TrimSuffix(settings.APIURL, "/")
+	if baseURL == "" {
+		baseURL = defaultAPIURL(settings.Provider)
+	}
+	r := settings.Request
+	switch settings.Provider {
+	case ProviderAnthropic:
+		return &anthropicProvider{baseURL: baseURL, apiKey: settings.APIKey, client: client, params: anthropicParams(r)}
+	case ProviderOllama:
+		return &ollamaProvider{baseURL: baseURL, apiKey: settings.APIKey, client: client, params: ollamaParams(r)}
+	}
+	params := r.params()
+	mergeParams(params, r.ExtraBody)
+	return newOpenAIProvider(baseURL, settings.APIKey, &paramsDoer{client: client, params: params})
+}
+
+// defaultAPIURL returns the API URL used when api_url is not set, which is
+// empty for providers without a usual one.
+func defaultAPIURL(provider string) string {
+	switch provider {
+	case ProviderAnthropic:
+		return "https://api.anthropic.com/v1"
+	case ProviderOllama:
+		return "http://localhost:11434"
+	}
+	return ""
+}
+
+func validProvider(provider string) bool {
+	switch provider {
+	case "", ProviderOpenAI, ProviderAnthropic, ProviderOllama:
+		return true
+	}
+	return false
+}
+
+// APIError is an error response of the Anthropic or Ollama API. Errors of
+// the OpenAI API are reported as *openai.APIError.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Type       string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Type != "" {
+		return fmt.Sprintf("%s error, status code: %d, type: %s, message: %s", e.Provider, e.StatusCode, e.Type, e.Message)
+	}
+	return fmt.Sprintf("%s error, status code: %d, message: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// postJSON sends body as JSON with params merged into it to url.
+// Responses with an error status are closed and passed to parseError.
+func postJSON(ctx context.Context, client *http.Client, url string, header http.Header, body any, params map[string]any, parseError func(status int, body []byte) error) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = mergeJSON(data, params); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return nil, parseError(resp.StatusCode, data)
+	}
+	return resp, nil
+}
+
+// toolArguments returns the arguments of a tool call as a JSON object, for
+// APIs that take them as one rather than as a string.
+func toolArguments(tc ToolCall) json.RawMessage {
+	args := json.RawMessage(tc.Function.Arguments)
+	if !json.Valid(args) || !bytes.HasPrefix(bytes.TrimSpace(args), []byte("{")) {
+		return json.RawMessage("{}")
+	}
+	return args
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anthropicVersion = "2023-06-01"
+	// anthropicMaxTokens is the max_tokens of requests, which the Messages
+	// API requires.
+	anthropicMaxTokens = 8192
+)
+
+// anthropicProvider talks to the Anthropic Messages API.
+type anthropicProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+	params  map[string]any
+}
+
+// anthropicParams maps the request settings to the Messages API, which
+// calls stop sequences stop_sequences and has no seed. reasoning_effort is
+// not used: thinking needs its blocks sent back, which sessions do not keep.
+func anthropicParams(r RequestSettings) map[string]any {
+	p := r.params()
+	if stop, ok := p["stop"]; ok {
+		p["stop_sequences"] = stop
+	}
+	delete(p, "stop")
+	delete(p, "seed")
+	delete(p, "reasoning_effort")
+	mergeParams(p, r.ExtraBody)
+	return p
+}
+
+type anthropicRequest struct {
+	Model     string             `json:"model"`
+	MaxTokens int                `json:"max_tokens"`
+	System    string             `json:"system,omitempty"`
+	Messages  []anthropicMessage `json:"messages"`
+	Tools     []anthropicTool    `json:"tools,omitempty"`
+	Stream    bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+// anthropicBlock is a content block of any type; only the fields of its
+// type are set.
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	Thinking  string          `json:"thinking,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	Content []anthropicBlock `json:"content"`
+	Usage   anthropicUsage   `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+func (u anthropicUsage) usage() Usage {
+	prompt := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	return Usage{
+		PromptTokens:     prompt,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      prompt + u.OutputTokens,
+		CachedTokens:     u.CacheReadInputTokens,
+	}
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// anthropicEvent is an event of a streamed response.
+type anthropicEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message"`
+	ContentBlock *anthropicBlock    `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		Thinking    string `json:"thinking"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *anthropicError `json:"error"`
+}
+
+func (p *anthropicProvider) Complete(ctx context.Context, req CompletionRequest, onDelta DeltaFunc) (Completion, error) {
+	areq := anthropicRequest{Model: req.Model, MaxTokens: anthropicMaxTokens, Stream: onDelta != nil}
+	areq.System, areq.Messages = toAnthropicMessages(req.Messages)
+	for _, t := range req.Tools {
+		areq.Tools = append(areq.Tools, anthropicTool{Name: t.Name, Description: t.Description, InputSchema: t.Parameters})
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", p.apiKey)
+	header.Set("anthropic-version", anthropicVersion)
+	resp, err := postJSON(ctx, p.client, p.baseURL+"/messages", header, areq, p.params, parseAnthropicError)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	if onDelta != nil {
+		return readAnthropicStream(resp.Body, onDelta)
+	}
+	var aresp anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&aresp); err != nil {
+		return Completion{}, err
+	}
+	return Completion{Message: fromAnthropicBlocks(aresp.Content), Usage: aresp.Usage.usage()}, nil
+}
+
+// toAnthropicMessages converts messages to the Messages API, which takes
+// the system prompt separately and tool results in user messages.
+// Reasoning is dropped: thinking blocks are only accepted back with the
+// signature the API gave them.
+func toAnthropicMessages(msgs []Message) (string, []anthropicMessage) {
+	var system []string
+	var out []anthropicMessage
+	for _, m := range msgs {
+		role := m.Role
+		var blocks []anthropicBlock
+		switch m.Role {
+		case RoleSystem:
+			system = append(system, m.Content)
+			continue
+		case RoleTool:
+			role = RoleUser
+			blocks = append(blocks, anthropicBlock{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content})
+		default:
+			if m.Content != "" {
+				blocks = append(blocks, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, tc := range m.ToolCalls {
+				blocks = append(blocks, anthropicBlock{Type: "tool_use", ID: tc.ID, Name: tc.Function.Name, Input: toolArguments(tc)})
+			}
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		// Consecutive messages of a role, such as several tool results,
+		// become one message.
+		if n := len(out); n > 0 && out[n-1].Role == role {
+			out[n-1].Content = append(out[n-1].Content, blocks...)
+			continue
+		}
+		out = append(out, anthropicMessage{Role: role, Content: blocks})
+	}
+	return strings.Join(system, "\n\n"), out
+}
+
+func fromAnthropicBlocks(blocks []anthropicBlock) Message {
+	msg := Message{Role: RoleAssistant}
+	var content, reasoning strings.Builder
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			content.WriteString(b.Text)
+		case "thinking":
+			reasoning.WriteString(b.Thinking)
+		case "tool_use":
+			input := b.Input
+			if len(input) == 0 {
+				input = json.RawMessage("{}")
+			}
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       b.ID,
+				Type:     ToolTypeFunction,
+				Function: FunctionCall{Name: b.Name, Arguments: string(input)},
+			})
+		}
+	}
+	msg.Content = content.String()
+	msg.ReasoningContent = reasoning.String()
+	return msg
+}
+
+// readAnthropicStream reads the server-sent events of a streamed response.
+func readAnthropicStream(r io.Reader, onDelta DeltaFunc) (Completion, error) {
+	var blocks []anthropicBlock
+	var inputs []strings.Builder
+	var usage anthropicUsage
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			continue
+		}
+		var ev anthropicEvent
+		if err := json.Unmarshal(bytes.TrimSpace(data), &ev); err != nil {
+			return Completion{}, err
+		}
+
+		switch ev.Type {
+		case "message_start":
+			if ev.Message != nil {
+				usage = ev.Message.Usage
+			}
+		case "content_block_start":
+			for len(blocks) <= ev.Index {
+				blocks = append(blocks, anthropicBlock{})
+				inputs = append(inputs, strings.Builder{})
+			}
+			if ev.ContentBlock != nil {
+				blocks[ev.Index] = *ev.ContentBlock
+			}
+		case "content_block_delta":
+			if ev.Index >= len(blocks) {
+				continue
+			}
+			switch ev.Delta.Type {
+			case "text_delta":
+				blocks[ev.Index].Text += ev.Delta.Text
+				onDelta("", ev.Delta.Text)
+			case "thinking_delta":
+				blocks[ev.Index].Thinking += ev.Delta.Thinking
+				onDelta(ev.Delta.Thinking, "")
+			case "input_json_delta":
+				inputs[ev.Index].WriteString(ev.Delta.PartialJSON)
+			}
+		case "message_delta":
+			if ev.Usage != nil {
+				usage.OutputTokens = ev.Usage.OutputTokens
+			}
+		case "error":
+			if ev.Error != nil {
+				return Completion{}, &APIError{Provider: ProviderAnthropic, StatusCode: anthropicErrorStatus(ev.Error.Type), Type: ev.Error.Type, Message: ev.Error.Message}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Completion{}, err
+	}
+
+	for i := range blocks {
+		if blocks[i].Type == "tool_use" && inputs[i].Len() > 0 {
+			blocks[i].Input = json.RawMessage(inputs[i].String())
+		}
+	}
+	return Completion{Message: fromAnthropicBlocks(blocks), Usage: usage.usage()}, nil
+}
+
+func parseAnthropicError(status int, body []byte) error {
+	var resp struct {
+		Error anthropicError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error.Message == "" {
+		return &APIError{Provider: ProviderAnthropic, StatusCode: status, Message: strings.TrimSpace(string(body))}
+	}
+	return &APIError{Provider: ProviderAnthropic, StatusCode: status, Type: resp.Error.Type, Message: resp.Error.Message}
+}
+
+// anthropicErrorStatus returns the HTTP status of an error type, for errors
+// reported in the middle of a stream.
+func anthropicErrorStatus(typ string) int {
+	switch typ {
+	case "overloaded_error":
+		return 529
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "api_error":
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
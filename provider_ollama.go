@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ollamaProvider talks to the native chat API of Ollama.
+type ollamaProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+	params  map[string]any
+}
+
+// ollamaParams maps the request settings to the Ollama API, which takes
+// sampling parameters in options and the reasoning effort as think.
+func ollamaParams(r RequestSettings) map[string]any {
+	options := r.params()
+	p := map[string]any{}
+	if effort, ok := options["reasoning_effort"]; ok {
+		p["think"] = effort
+		delete(options, "reasoning_effort")
+	}
+	if n, ok := options["max_tokens"]; ok {
+		options["num_predict"] = n
+		delete(options, "max_tokens")
+	}
+	if len(options) > 0 {
+		p["options"] = options
+	}
+	mergeParams(p, r.ExtraBody)
+	return p
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []ollamaTool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaTool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+// ollamaResponse is a response, or with streaming one line of it.
+type ollamaResponse struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+func (p *ollamaProvider) Complete(ctx context.Context, req CompletionRequest, onDelta DeltaFunc) (Completion, error) {
+	oreq := ollamaRequest{Model: req.Model, Messages: toOllamaMessages(req.Messages), Stream: onDelta != nil}
+	for _, t := range req.Tools {
+		var tool ollamaTool
+		tool.Type = ToolTypeFunction
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		tool.Function.Parameters = t.Parameters
+		oreq.Tools = append(oreq.Tools, tool)
+	}
+
+	header := http.Header{}
+	if p.apiKey != "" {
+		header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+	resp, err := postJSON(ctx, p.client, p.baseURL+"/api/chat", header, oreq, p.params, parseOllamaError)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+	return readOllamaResponse(resp.Body, onDelta)
+}
+
+// toOllamaMessages converts messages to the Ollama API, which takes tool
+// arguments as objects and names the tool of a result instead of the call.
+func toOllamaMessages(msgs []Message) []ollamaMessage {
+	names := map[string]string{}
+	out := make([]ollamaMessage, 0, len(msgs))
+	for _, m := range msgs {
+		om := ollamaMessage{Role: m.Role, Content: m.Content, Thinking: m.ReasoningContent}
+		for _, tc := range m.ToolCalls {
+			names[tc.ID] = tc.Function.Name
+			var call ollamaToolCall
+			call.Function.Name = tc.Function.Name
+			call.Function.Arguments = toolArguments(tc)
+			om.ToolCalls = append(om.ToolCalls, call)
+		}
+		if m.Role == RoleTool {
+			om.ToolName = names[m.ToolCallID]
+		}
+		out = append(out, om)
+	}
+	return out
+}
+
+// readOllamaResponse reads a response, which is one JSON object, or with
+// streaming one per line. Ollama does not give tool calls IDs, so they get
+// new ones.
+func readOllamaResponse(r io.Reader, onDelta DeltaFunc) (Completion, error) {
+	msg := Message{Role: RoleAssistant}
+	var content, reasoning strings.Builder
+	var usage Usage
+
+	dec := json.NewDecoder(r)
+	for {
+		var chunk ollamaResponse
+		err := dec.Decode(&chunk)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Completion{}, err
+		}
+		if chunk.Error != "" {
+			return Completion{}, &APIError{Provider: ProviderOllama, StatusCode: http.StatusInternalServerError, Message: chunk.Error}
+		}
+
+		content.WriteString(chunk.Message.Content)
+		reasoning.WriteString(chunk.Message.Thinking)
+		if onDelta != nil {
+			onDelta(chunk.Message.Thinking, chunk.Message.Content)
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       "call_" + newID(),
+				Type:     ToolTypeFunction,
+				Function: FunctionCall{Name: tc.Function.Name, Arguments: string(tc.Function.Arguments)},
+			})
+		}
+		if chunk.Done {
+			usage = Usage{
+				PromptTokens:     chunk.PromptEvalCount,
+				CompletionTokens: chunk.EvalCount,
+				TotalTokens:      chunk.PromptEvalCount + chunk.EvalCount,
+			}
+		}
+	}
+
+	msg.Content = content.String()
+	msg.ReasoningContent = reasoning.String()
+	return Completion{Message: msg, Usage: usage}, nil
+}
+
+func parseOllamaError(status int, body []byte) error {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Error == "" {
+		return &APIError{Provider: ProviderOllama, StatusCode: status, Message: strings.TrimSpace(string(body))}
+	}
+	return &APIError{Provider: ProviderOllama, StatusCode: status, Message: resp.Error}
+}
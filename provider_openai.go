@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// openaiProvider talks to OpenAI and the many servers compatible with its
+// chat completions API.
+type openaiProvider struct {
+	client *openai.Client
+}
+
+func newOpenAIProvider(baseURL, apiKey string, client openai.HTTPDoer) *openaiProvider {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = baseURL
+	}
+	config.HTTPClient = client
+	return &openaiProvider{client: openai.NewClientWithConfig(config)}
+}
+
+func (p *openaiProvider) Complete(ctx context.Context, req CompletionRequest, onDelta DeltaFunc) (Completion, error) {
+	oreq := openai.ChatCompletionRequest{Model: req.Model}
+	for _, m := range req.Messages {
+		oreq.Messages = append(oreq.Messages, toOpenAIMessage(m))
+	}
+	for _, t := range req.Tools {
+		oreq.Tools = append(oreq.Tools, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.Parameters,
+			},
+		})
+	}
+
+	if onDelta != nil {
+		return p.stream(ctx, oreq, onDelta)
+	}
+	resp, err := p.client.CreateChatCompletion(ctx, oreq)
+	if err != nil {
+		return Completion{}, err
+	}
+	if len(resp.Choices) == 0 {
+		return Completion{}, errEmptyResponse
+	}
+	return Completion{
+		Message: fromOpenAIMessage(resp.Choices[0].Message),
+		Usage:   fromOpenAIUsage(resp.Usage),
+	}, nil
+}
+
+// stream streams a completion, passing its deltas to onDelta.
+func (p *openaiProvider) stream(ctx context.Context, req openai.ChatCompletionRequest, onDelta DeltaFunc) (Completion, error) {
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return Completion{}, err
+	}
+	defer stream.Close()
+
+	var acc streamAccumulator
+	var usage openai.Usage
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Completion{}, err
+		}
+		if resp.Usage != nil {
+			usage = *resp.Usage
+		}
+		if len(resp.Choices) == 0 {
+			continue
+		}
+
+		delta := resp.Choices[0].Delta
+		acc.add(delta)
+		onDelta(delta.ReasoningContent, delta.Content)
+	}
+
+	return Completion{
+		Message: fromOpenAIMessage(acc.message()),
+		Usage:   fromOpenAIUsage(usage),
+	}, nil
+}
+
+func toOpenAIMessage(m Message) openai.ChatCompletionMessage {
+	msg := openai.ChatCompletionMessage{
+		Role:             m.Role,
+		Content:          m.Content,
+		ReasoningContent: m.ReasoningContent,
+		ToolCallID:       m.ToolCallID,
+	}
+	for _, tc := range m.ToolCalls {
+		msg.ToolCalls = append(msg.ToolCalls, openai.ToolCall{
+			ID:       tc.ID,
+			Type:     openai.ToolType(tc.Type),
+			Function: openai.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+		})
+	}
+	return msg
+}
+
+func fromOpenAIMessage(m openai.ChatCompletionMessage) Message {
+	msg := Message{
+		Role:             m.Role,
+		Content:          m.Content,
+		ReasoningContent: m.ReasoningContent,
+		ToolCallID:       m.ToolCallID,
+	}
+	for _, tc := range m.ToolCalls {
+		msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+			ID:       tc.ID,
+			Type:     string(tc.Type),
+			Function: FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments},
+		})
+	}
+	return msg
+}
+
+func fromOpenAIUsage(u openai.Usage) Usage {
+	usage := Usage{
+		PromptTokens:     u.PromptTokens,
+		CompletionTokens: u.CompletionTokens,
+		TotalTokens:      u.TotalTokens,
+	}
+	if d := u.PromptTokensDetails; d != nil {
+		usage.CachedTokens = d.CachedTokens
+	}
+	if d := u.CompletionTokensDetails; d != nil {
+		usage.ReasoningTokens = d.ReasoningTokens
+	}
+	return usage
+}
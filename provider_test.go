@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// providerMessages is a conversation with a tool call, as stored in sessions.
+var providerMessages = []Message{
+	{Role: RoleSystem, Content: "be brief"},
+	{Role: RoleUser, Content: "list files"},
+	{Role: RoleAssistant, ToolCalls: []ToolCall{
+		{ID: "call_1", Type: ToolTypeFunction, Function: FunctionCall{Name: "bash", Arguments: `{"command":"ls"}`}},
+		{ID: "call_2", Type: ToolTypeFunction, Function: FunctionCall{Name: "bash", Arguments: `{"command":"pwd"}`}},
+	}},
+	{Role: RoleTool, ToolCallID: "call_1", Content: "a.txt"},
+	{Role: RoleTool, ToolCallID: "call_2", Content: "/tmp"},
+}
+
+func TestMessageJSON(t *testing.T) {
+	// A line written by earlier versions through go-openai.
+	line := `{"role":"assistant","content":"ok","reasoning_content":"hm","tool_calls":[{"id":"1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"ls\"}"}}]}`
+	msg, err := decodeMessage([]byte(line))
+	if err != nil {
+		t.Fatalf("decodeMessage failed: %v", err)
+	}
+	if msg.ReasoningContent != "hm" || len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	data, _ := json.Marshal(msg)
+	if string(data) != line {
+		t.Errorf("round trip changed the line:\n%s\n%s", data, line)
+	}
+}
+
+func TestAnthropicProvider(t *testing.T) {
+	var got anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" || r.Header.Get("x-api-key") != "key" || r.Header.Get("anthropic-version") == "" {
+			t.Errorf("unexpected request %s %v", r.URL.Path, r.Header)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content":[
+			{"type":"thinking","thinking":"hmm","signature":"x"},
+			{"type":"text","text":"Running it."},
+			{"type":"tool_use","id":"toolu_1","name":"bash","input":{"command":"ls"}}
+		],"usage":{"input_tokens":10,"cache_read_input_tokens":5,"output_tokens":3}}`)
+	}))
+	defer server.Close()
+
+	p := NewProvider(Settings{Provider: ProviderAnthropic, APIURL: server.URL + "/v1", APIKey: "key"}, http.DefaultClient)
+	resp, err := p.Complete(context.Background(), CompletionRequest{Model: "claude", Messages: providerMessages, Tools: tools}, nil)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if got.System != "be brief" || got.MaxTokens == 0 || len(got.Tools) != 1 || got.Tools[0].Name != "bash" {
+		t.Errorf("unexpected request %+v", got)
+	}
+	// Both tool results go in one user message after the tool uses.
+	if len(got.Messages) != 3 {
+		t.Fatalf("expected 3 messages, got %+v", got.Messages)
+	}
+	if m := got.Messages[1]; m.Role != RoleAssistant || len(m.Content) != 2 || m.Content[0].Type != "tool_use" || string(m.Content[0].Input) != `{"command":"ls"}` {
+		t.Errorf("unexpected assistant message %+v", m)
+	}
+	if m := got.Messages[2]; m.Role != RoleUser || len(m.Content) != 2 || m.Content[1].ToolUseID != "call_2" || m.Content[1].Content != "/tmp" {
+		t.Errorf("unexpected tool results %+v", m)
+	}
+
+	msg := resp.Message
+	if msg.Content != "Running it." || msg.ReasoningContent != "hmm" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID != "toolu_1" || msg.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected tool calls %+v", msg.ToolCalls)
+	}
+	if resp.Usage.PromptTokens != 15 || resp.Usage.CachedTokens != 5 || resp.Usage.TotalTokens != 18 {
+		t.Errorf("unexpected usage %+v", resp.Usage)
+	}
+}
+
+func TestAnthropicProviderStream(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"content":[],"usage":{"input_tokens":10,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Run"}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"ning."}}`,
+		`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"bash","input":{}}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"command\":"}}`,
+		`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"ls\"}"}}`,
+		`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+		`{"type":"message_stop"}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, ev := range events {
+			fmt.Fprintf(w, "event: x\ndata: %s\n\n", ev)
+		}
+	}))
+	defer server.Close()
+
+	p := NewProvider(Settings{Provider: ProviderAnthropic, APIURL: server.URL}, http.DefaultClient)
+	var deltas []string
+	resp, err := p.Complete(context.Background(), CompletionRequest{Model: "claude", Messages: providerMessages[:2]}, func(reasoning, content string) {
+		deltas = append(deltas, content)
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if strings.Join(deltas, "|") != "Run|ning." {
+		t.Errorf("unexpected deltas %q", deltas)
+	}
+	msg := resp.Message
+	if msg.Content != "Running." || len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if resp.Usage.PromptTokens != 10 || resp.Usage.CompletionTokens != 7 {
+		t.Errorf("unexpected usage %+v", resp.Usage)
+	}
+}
+
+func TestAnthropicProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(529)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`)
+	}))
+	defer server.Close()
+
+	p := NewProvider(Settings{Provider: ProviderAnthropic, APIURL: server.URL}, http.DefaultClient)
+	_, err := p.Complete(context.Background(), CompletionRequest{Model: "claude", Messages: providerMessages[:2]}, nil)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 529 || apiErr.Type != "overloaded_error" {
+		t.Fatalf("expected an overloaded error, got %v", err)
+	}
+	if retryable, _ := classifyError(err); !retryable {
+		t.Errorf("expected %v to be retryable", err)
+	}
+}
+
+func TestOllamaProvider(t *testing.T) {
+	var got ollamaRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"","thinking":"hmm","tool_calls":[
+			{"function":{"name":"bash","arguments":{"command":"ls"}}}
+		]},"done":true,"prompt_eval_count":12,"eval_count":4}`)
+	}))
+	defer server.Close()
+
+	p := NewProvider(Settings{Provider: ProviderOllama, APIURL: server.URL + "/"}, http.DefaultClient)
+	resp, err := p.Complete(context.Background(), CompletionRequest{Model: "qwen", Messages: providerMessages, Tools: tools}, nil)
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if got.Stream || len(got.Messages) != 5 || len(got.Tools) != 1 {
+		t.Errorf("unexpected request %+v", got)
+	}
+	if m := got.Messages[2]; len(m.ToolCalls) != 2 || string(m.ToolCalls[1].Function.Arguments) != `{"command":"pwd"}` {
+		t.Errorf("unexpected assistant message %+v", m)
+	}
+	if m := got.Messages[4]; m.Role != RoleTool || m.ToolName != "bash" || m.Content != "/tmp" {
+		t.Errorf("unexpected tool result %+v", m)
+	}
+
+	msg := resp.Message
+	if msg.ReasoningContent != "hmm" || len(msg.ToolCalls) != 1 || msg.ToolCalls[0].ID == "" || msg.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if resp.Usage.PromptTokens != 12 || resp.Usage.TotalTokens != 16 {
+		t.Errorf("unexpected usage %+v", resp.Usage)
+	}
+}
+
+func TestOllamaProviderStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req ollamaRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if !req.Stream {
+			t.Errorf("expected a streaming request")
+		}
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"Hel"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":"lo"},"done":false}`)
+		fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"prompt_eval_count":3,"eval_count":2}`)
+	}))
+	defer server.Close()
+
+	p := NewProvider(Settings{Provider: ProviderOllama, APIURL: server.URL}, http.DefaultClient)
+	var deltas []string
+	resp, err := p.Complete(context.Background(), CompletionRequest{Model: "qwen", Messages: providerMessages[:2]}, func(reasoning, content string) {
+		if content != "" {
+			deltas = append(deltas, content)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	if strings.Join(deltas, "|") != "Hel|lo" || resp.Message.Content != "Hello" || resp.Usage.TotalTokens != 5 {
+		t.Errorf("unexpected response %+v (deltas %q)", resp, deltas)
+	}
+}
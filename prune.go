@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneSettings are the retention rules of `saa session prune`. A session is
+// removed when any rule selects it; zero values disable a rule.
+type PruneSettings struct {
+	KeepLast int    `mapstructure:"keep_last" json:"keep_last,omitempty"`
+	MaxAge   string `mapstructure:"max_age" json:"max_age,omitempty"`
+	MaxSize  string `mapstructure:"max_size" json:"max_size,omitempty"`
+	Auto     bool   `mapstructure:"auto" json:"auto,omitempty"`
+}
+
+// orphanLogGrace keeps fresh logs whose tool result may not be written yet.
+const orphanLogGrace = 10 * time.Minute
+
+// outputLogRe matches the file names of truncated output logs.
+var outputLogRe = regexp.MustCompile(`^[0-9]{8}-[0-9]{6}-[0-9a-f]{8}\.(stdout|stderr)\.log$`)
+
+// prunePolicy is PruneSettings with its values parsed.
+type prunePolicy struct {
+	keepLast int
+	maxAge   time.Duration
+	maxSize  int64
+}
+
+func newPrunePolicy(s PruneSettings) (prunePolicy, error) {
+	p := prunePolicy{keepLast: s.KeepLast}
+	if s.MaxAge != "" {
+		d, err := parseAge(s.MaxAge)
+		if err != nil {
+			return p, fmt.Errorf("invalid max_age: %w", err)
+		}
+		p.maxAge = d
+	}
+	if s.MaxSize != "" {
+		n, err := parseSize(s.MaxSize)
+		if err != nil {
+			return p, fmt.Errorf("invalid max_size: %w", err)
+		}
+		p.maxSize = n
+	}
+	return p, nil
+}
+
+// parseAge parses a duration such as 30d or 12h.
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration like 30d or 12h", s)
+	}
+	return d, nil
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix (powers
+// of 1024, an optional trailing B is ignored).
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(num, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(num, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(num, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = num[:len(num)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(num), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size like 500M", s)
+	}
+	return n * mult, nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
+
+// prunedSession is a session file with its sidecars and the output logs its
+// tool results refer to.
+type prunedSession struct {
+	Name    string
+	Files   []string
+	Logs    []string
+	Size    int64
+	Updated time.Time
+}
+
+// PruneResult lists what Prune removed, or would remove in a dry run.
+type PruneResult struct {
+	Sessions []string
+	Logs     []string
+	Freed    int64
+}
+
+// Prune removes the sessions selected by the retention rules, together with
+// their sidecars and output logs, and any output log no session refers to.
+// The current session and sessions in use by another process are never
+// removed. With dryRun nothing is deleted.
+func (s *Session) Prune(settings PruneSettings, dryRun bool) (PruneResult, error) {
+	var result PruneResult
+	policy, err := newPrunePolicy(settings)
+	if err != nil {
+		return result, err
+	}
+
+	names, err := s.List()
+	if err != nil {
+		return result, err
+	}
+	current, _ := s.GetCurrentLogFile()
+
+	var sessions []prunedSession
+	for _, name := range names {
+		ps, err := s.statSession(name)
+		if err != nil {
+			return result, err
+		}
+		sessions = append(sessions, ps)
+	}
+	// The current session first, then the most recently used; it counts
+	// towards keep_last.
+	sort.SliceStable(sessions, func(i, j int) bool {
+		if (sessions[i].Name == current) != (sessions[j].Name == current) {
+			return sessions[i].Name == current
+		}
+		return sessions[i].Updated.After(sessions[j].Updated)
+	})
+
+	now := time.Now()
+	remove := make(map[string]bool)
+	var total int64
+	for i, ps := range sessions {
+		if ps.Name == current || sessionBusy(filepath.Join(s.SessionDir, ps.Name)) {
+			total += ps.Size
+			continue
+		}
+		if (policy.keepLast > 0 && i >= policy.keepLast) ||
+			(policy.maxAge > 0 && now.Sub(ps.Updated) > policy.maxAge) {
+			remove[ps.Name] = true
+			continue
+		}
+		total += ps.Size
+	}
+	if policy.maxSize > 0 {
+		for i := len(sessions) - 1; i >= 0 && total > policy.maxSize; i-- {
+			ps := sessions[i]
+			if ps.Name != current && !remove[ps.Name] && !sessionBusy(filepath.Join(s.SessionDir, ps.Name)) {
+				remove[ps.Name] = true
+				total -= ps.Size
+			}
+		}
+	}
+
+	var files []string
+	kept := make(map[string]bool)
+	for _, ps := range sessions {
+		if !remove[ps.Name] {
+			for _, l := range ps.Logs {
+				kept[l] = true
+			}
+			continue
+		}
+		result.Sessions = append(result.Sessions, ps.Name)
+		files = append(files, ps.Files...)
+	}
+
+	entries, err := os.ReadDir(s.SessionDir)
+	if err != nil {
+		return result, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || !outputLogRe.MatchString(e.Name()) || kept[e.Name()] {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil || now.Sub(fi.ModTime()) < orphanLogGrace {
+			continue
+		}
+		result.Logs = append(result.Logs, e.Name())
+		files = append(files, filepath.Join(s.SessionDir, e.Name()))
+	}
+
+	for _, path := range files {
+		if fi, err := os.Stat(path); err == nil {
+			result.Freed += fi.Size()
+		}
+		if dryRun {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return result, err
+		}
+	}
+	if dryRun || len(result.Sessions) == 0 {
+		return result, nil
+	}
+
+	// The search index would otherwise keep the text of removed sessions
+	// until the next indexed search.
+	idx := loadSearchIndex(searchIndexPath(s.Config))
+	idx.prune(slices.DeleteFunc(names, func(name string) bool { return remove[name] }))
+	return result, idx.save()
+}
+
+// statSession collects the files that belong to the session file name:
+// its sidecars and the git index of its snapshots. The snapshots themselves
+// share one repository and stay.
+func (s *Session) statSession(name string) (prunedSession, error) {
+	path := filepath.Join(s.SessionDir, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return prunedSession{}, err
+	}
+	ps := prunedSession{Name: name, Files: []string{path}, Size: fi.Size(), Updated: fi.ModTime()}
+
+	sidecars := []string{metaPath(path), usagePath(path), rewoundPath(path), lockPath(path), backupPath(path), snapshotIndexPath(s.Config, sessionID(name))}
+	for _, sidecar := range sidecars {
+		if fi, err := os.Stat(sidecar); err == nil {
+			ps.Files = append(ps.Files, sidecar)
+			ps.Size += fi.Size()
+		}
+	}
+
+	// Rewound turns may be restored, so their logs belong to the session too.
+	seen := make(map[string]bool)
+	for _, f := range []string{path, rewoundPath(path)} {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		for _, m := range truncatedLogRe.FindAllSubmatch(data, -1) {
+			log := fmt.Sprintf("%s.%s.log", m[2], m[1])
+			if seen[log] {
+				continue
+			}
+			seen[log] = true
+			ps.Logs = append(ps.Logs, log)
+			if fi, err := os.Stat(filepath.Join(s.SessionDir, log)); err == nil {
+				ps.Size += fi.Size()
+			}
+		}
+	}
+	return ps, nil
+}
+
+// Print writes what was removed, or would be removed with dryRun.
+func (r PruneResult) Print(w io.Writer, dryRun bool) {
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	for _, name := range r.Sessions {
+		fmt.Fprintf(w, "%s session %s\n", verb, name)
+	}
+	for _, name := range r.Logs {
+		fmt.Fprintf(w, "%s log %s\n", verb, name)
+	}
+	fmt.Fprintf(w, "%s %d sessions and %d logs (%s)\n", verb, len(r.Sessions), len(r.Logs), formatSize(r.Freed))
+}
+
+// autoPrune applies the retention rules when prune.auto is set. Failures
+// only print a notice, since they should not stop a new session.
+func (s *Session) autoPrune(w io.Writer) {
+	settings := s.Config.Settings.Prune
+	if !settings.Auto {
+		return
+	}
+	result, err := s.Prune(settings, false)
+	if err != nil {
+		fmt.Fprintf(w, "Failed to prune sessions: %v\n", err)
+		return
+	}
+	if len(result.Sessions) > 0 || len(result.Logs) > 0 {
+		fmt.Fprintf(w, "Pruned %d sessions and %d logs (%s)\n", len(result.Sessions), len(result.Logs), formatSize(result.Freed))
+	}
+}
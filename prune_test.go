@@ -0,0 +1,143 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+// agePruneSession sets the modification time of a session file and its
+// sidecars to age ago.
+func agePruneSession(t *testing.T, session *Session, age time.Duration) {
+	t.Helper()
+	when := time.Now().Add(-age)
+	for _, f := range []string{session.LogFile, metaPath(session.LogFile)} {
+		if err := os.Chtimes(f, when, when); err != nil && !os.IsNotExist(err) {
+			t.Fatalf("Chtimes failed: %v", err)
+		}
+	}
+}
+
+func writeOutputLog(t *testing.T, dir, name string, age time.Duration) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("output\n"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	when := time.Now().Add(-age)
+	os.Chtimes(path, when, when)
+}
+
+func TestPrune(t *testing.T) {
+	session := newTestSession(t, "oldest")
+	oldest := filepath.Base(session.LogFile)
+	session.UpdateMeta()
+	logID := "20240101-000000-0000abcd"
+	writeOutputLog(t, session.SessionDir, logID+".stdout.log", time.Hour)
+	session.AddMessage(Message{
+		Role:    RoleTool,
+		Content: "Exit Code: 0\n... see `saa session stdout " + logID + "`",
+	})
+	agePruneSession(t, session, 72*time.Hour)
+
+	session.NewSession()
+	session.AddMessage(Message{Role: RoleUser, Content: "middle"})
+	middle := filepath.Base(session.LogFile)
+	agePruneSession(t, session, 48*time.Hour)
+
+	// The current session is older than the rest but must survive.
+	session.NewSession()
+	current := filepath.Base(session.LogFile)
+	agePruneSession(t, session, 96*time.Hour)
+
+	writeOutputLog(t, session.SessionDir, "20240101-000000-0000ffff.stderr.log", time.Hour)
+	writeOutputLog(t, session.SessionDir, "20240101-000000-0000eeee.stderr.log", 0)
+
+	snapshotIndex := snapshotIndexPath(session.Config, sessionID(oldest))
+	os.MkdirAll(filepath.Dir(snapshotIndex), 0755)
+	if err := os.WriteFile(snapshotIndex, []byte("index"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if _, err := session.Search(SearchOptions{Query: "oldest"}, true); err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	result, err := session.Prune(PruneSettings{MaxAge: "60h"}, true)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(result.Sessions) != 1 || result.Sessions[0] != oldest {
+		t.Errorf("dry run sessions = %v, want [%s]", result.Sessions, oldest)
+	}
+	if len(result.Logs) != 2 {
+		t.Errorf("dry run logs = %v, want the old orphan and the log of %s", result.Logs, oldest)
+	}
+	if _, err := os.Stat(filepath.Join(session.SessionDir, oldest)); err != nil {
+		t.Errorf("dry run removed a session: %v", err)
+	}
+
+	result, err = session.Prune(PruneSettings{KeepLast: 1}, false)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(result.Sessions) != 2 {
+		t.Errorf("removed sessions = %v, want %s and %s", result.Sessions, oldest, middle)
+	}
+	files, _ := session.List()
+	if len(files) != 1 || files[0] != current {
+		t.Errorf("remaining sessions = %v, want [%s]", files, current)
+	}
+	for _, f := range []string{metaPath(filepath.Join(session.SessionDir, oldest)), filepath.Join(session.SessionDir, logID+".stdout.log")} {
+		if _, err := os.Stat(f); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed", filepath.Base(f))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(session.SessionDir, "20240101-000000-0000eeee.stderr.log")); err != nil {
+		t.Errorf("a fresh log was removed: %v", err)
+	}
+	if _, err := os.Stat(snapshotIndex); !os.IsNotExist(err) {
+		t.Errorf("the snapshot index of %s was not removed", oldest)
+	}
+	if idx := loadSearchIndex(searchIndexPath(session.Config)); idx.Files[oldest] != nil || idx.Files[current] == nil {
+		t.Errorf("expected the search index to keep only %s, got %v", current, slices.Collect(maps.Keys(idx.Files)))
+	}
+}
+
+func TestPruneMaxSize(t *testing.T) {
+	session := newTestSession(t, "one")
+	first := filepath.Base(session.LogFile)
+	agePruneSession(t, session, 2*time.Hour)
+	session.NewSession()
+	agePruneSession(t, session, time.Hour)
+	session.NewSession()
+
+	result, err := session.Prune(PruneSettings{MaxSize: "1"}, false)
+	if err != nil {
+		t.Fatalf("Prune failed: %v", err)
+	}
+	if len(result.Sessions) != 2 || result.Sessions[1] != first {
+		t.Errorf("removed sessions = %v", result.Sessions)
+	}
+	if files, _ := session.List(); len(files) != 1 {
+		t.Errorf("remaining sessions = %v, want only the current one", files)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := map[string]int64{"100": 100, "2K": 2048, "1.5M": -1, "500MB": 500 << 20, "1g": 1 << 30}
+	for in, want := range tests {
+		got, err := parseSize(in)
+		if want < 0 {
+			if err == nil {
+				t.Errorf("parseSize(%q) succeeded", in)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RequestSettings are sampling and other parameters added to every model
+// request. Each provider sends the typed fields under its own names;
+// ExtraBody is merged into the request JSON as is, after them.
+type RequestSettings struct {
+	Temperature     *float64       `mapstructure:"temperature" json:"temperature,omitempty"`
+	TopP            *float64       `mapstructure:"top_p" json:"top_p,omitempty"`
+	MaxTokens       int            `mapstructure:"max_tokens" json:"max_tokens,omitempty"`
+	Seed            *int           `mapstructure:"seed" json:"seed,omitempty"`
+	Stop            []string       `mapstructure:"stop" json:"stop,omitempty"`
+	ReasoningEffort string         `mapstructure:"reasoning_effort" json:"reasoning_effort,omitempty"`
+	ExtraBody       map[string]any `mapstructure:"extra_body" json:"extra_body,omitempty"`
+}
+
+// IsZero reports whether no parameter is set, for omitzero.
+func (r RequestSettings) IsZero() bool {
+	return r.Temperature == nil && r.TopP == nil && r.MaxTokens == 0 && r.Seed == nil &&
+		len(r.Stop) == 0 && r.ReasoningEffort == "" && len(r.ExtraBody) == 0
+}
+
+// params returns the typed fields that are set, under their OpenAI names.
+func (r RequestSettings) params() map[string]any {
+	p := map[string]any{}
+	if r.Temperature != nil {
+		p["temperature"] = *r.Temperature
+	}
+	if r.TopP != nil {
+		p["top_p"] = *r.TopP
+	}
+	if r.MaxTokens > 0 {
+		p["max_tokens"] = r.MaxTokens
+	}
+	if r.Seed != nil {
+		p["seed"] = *r.Seed
+	}
+	if len(r.Stop) > 0 {
+		p["stop"] = r.Stop
+	}
+	if r.ReasoningEffort != "" {
+		p["reasoning_effort"] = r.ReasoningEffort
+	}
+	return p
+}
+
+// Set sets a parameter given as key=value on the command line. Keys of
+// typed fields set them; other keys go to ExtraBody, with dots separating
+// nested objects. Values are read as JSON if they parse, else as strings.
+func (r *RequestSettings) Set(param string) error {
+	key, raw, ok := strings.Cut(param, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid parameter %q (use key=value)", param)
+	}
+	var value any = raw
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+
+	switch key {
+	case "temperature", "top_p":
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", key, raw)
+		}
+		if key == "temperature" {
+			r.Temperature = &f
+		} else {
+			r.TopP = &f
+		}
+	case "max_tokens", "seed":
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %s", key, raw)
+		}
+		if key == "seed" {
+			r.Seed = &n
+		} else {
+			r.MaxTokens = n
+		}
+	case "stop":
+		r.Stop = nil
+		switch v := value.(type) {
+		case []any:
+			for _, s := range v {
+				r.Stop = append(r.Stop, fmt.Sprint(s))
+			}
+		default:
+			r.Stop = []string{raw}
+		}
+	case "reasoning_effort":
+		r.ReasoningEffort = raw
+	default:
+		if r.ExtraBody == nil {
+			r.ExtraBody = map[string]any{}
+		}
+		m := r.ExtraBody
+		parts := strings.Split(key, ".")
+		for _, p := range parts[:len(parts)-1] {
+			next, ok := m[p].(map[string]any)
+			if !ok {
+				next = map[string]any{}
+				m[p] = next
+			}
+			m = next
+		}
+		m[parts[len(parts)-1]] = value
+	}
+	return nil
+}
+
+// mergeParams merges src into dst, recursing into objects present in both.
+func mergeParams(dst, src map[string]any) {
+	for k, v := range src {
+		if sub, ok := v.(map[string]any); ok {
+			if dsub, ok := dst[k].(map[string]any); ok {
+				mergeParams(dsub, sub)
+				continue
+			}
+			v = maps.Clone(sub)
+		}
+		dst[k] = v
+	}
+}
+
+// mergeJSON merges params into a JSON object.
+func mergeJSON(data []byte, params map[string]any) ([]byte, error) {
+	if len(params) == 0 {
+		return data, nil
+	}
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		return nil, err
+	}
+	mergeParams(body, params)
+	return json.Marshal(body)
+}
+
+// paramsDoer adds parameters to the JSON body of requests sent through
+// go-openai, which only knows its own fields.
+type paramsDoer struct {
+	client *http.Client
+	params map[string]any
+}
+
+func (d *paramsDoer) Do(req *http.Request) (*http.Response, error) {
+	if len(d.params) == 0 || req.Body == nil || req.Method != http.MethodPost {
+		return d.client.Do(req)
+	}
+	data, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if data, err = mergeJSON(data, d.params); err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(data))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	req.ContentLength = int64(len(data))
+	return d.client.Do(req)
+}
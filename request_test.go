@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestRequestSettingsSet(t *testing.T) {
+	var r RequestSettings
+	for _, p := range []string{
+		"temperature=0",
+		"max_tokens=100",
+		`stop=["END","STOP"]`,
+		"reasoning_effort=high",
+		"options.num_ctx=8192",
+		"options.mirostat=2",
+		"user=alice",
+	} {
+		if err := r.Set(p); err != nil {
+			t.Fatalf("Set(%q) failed: %v", p, err)
+		}
+	}
+
+	if r.Temperature == nil || *r.Temperature != 0 || r.MaxTokens != 100 || r.ReasoningEffort != "high" {
+		t.Errorf("unexpected settings %+v", r)
+	}
+	if !reflect.DeepEqual(r.Stop, []string{"END", "STOP"}) {
+		t.Errorf("unexpected stop %q", r.Stop)
+	}
+	want := map[string]any{"options": map[string]any{"num_ctx": 8192.0, "mirostat": 2.0}, "user": "alice"}
+	if !reflect.DeepEqual(r.ExtraBody, want) {
+		t.Errorf("unexpected extra_body %v", r.ExtraBody)
+	}
+
+	for _, p := range []string{"temperature", "=1", "seed=x"} {
+		if err := r.Set(p); err == nil {
+			t.Errorf("Set(%q) should fail", p)
+		}
+	}
+}
+
+func TestRequestSettingsConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+	saaDir := filepath.Join(tmpDir, ".saa")
+	if err := os.Mkdir(saaDir, 0755); err != nil {
+		t.Fatalf("failed to create .saa dir: %v", err)
+	}
+	configContent := `{"request": {"temperature": 0, "seed": 7, "extra_body": {"options": {"num_ctx": 4096}}}}`
+	if err := os.WriteFile(filepath.Join(saaDir, "config.json"), []byte(configContent), 0644); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	if err := os.Chdir(tmpDir); err != nil {
+		t.Fatalf("failed to chdir: %v", err)
+	}
+
+	viper.Reset()
+	config, err := NewConfig()
+	if err != nil {
+		t.Fatalf("NewConfig failed: %v", err)
+	}
+	r := config.Settings.Request
+	if r.Temperature == nil || *r.Temperature != 0 || r.Seed == nil || *r.Seed != 7 || r.TopP != nil {
+		t.Errorf("unexpected settings %+v", r)
+	}
+	if options, _ := r.ExtraBody["options"].(map[string]any); fmt.Sprint(options["num_ctx"]) != "4096" {
+		t.Errorf("unexpected extra_body %v", r.ExtraBody)
+	}
+}
+
+func TestRequestParams(t *testing.T) {
+	temperature := 0.0
+	seed := 7
+	request := RequestSettings{
+		Temperature: &temperature,
+		MaxTokens:   100,
+		Seed:        &seed,
+		Stop:        []string{"END"},
+		ExtraBody:   map[string]any{"options": map[string]any{"num_ctx": 8192}, "user": "alice"},
+	}
+
+	tests := []struct {
+		provider string
+		response string
+		want     string
+	}{
+		{
+			ProviderOpenAI,
+			`{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"}}]}`,
+			`{"max_tokens":100,"options":{"num_ctx":8192},"seed":7,"stop":["END"],"temperature":0,"user":"alice"}`,
+		},
+		{
+			ProviderAnthropic,
+			`{"content":[{"type":"text","text":"ok"}]}`,
+			`{"max_tokens":100,"options":{"num_ctx":8192},"stop_sequences":["END"],"temperature":0,"user":"alice"}`,
+		},
+		{
+			ProviderOllama,
+			`{"message":{"role":"assistant","content":"ok"},"done":true}`,
+			`{"options":{"num_ctx":8192,"num_predict":100,"seed":7,"stop":["END"],"temperature":0},"user":"alice"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.provider, func(t *testing.T) {
+			var got map[string]any
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&got)
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprint(w, tt.response)
+			}))
+			defer server.Close()
+
+			p := NewProvider(Settings{Provider: tt.provider, APIURL: server.URL, Request: request}, http.DefaultClient)
+			if _, err := p.Complete(context.Background(), CompletionRequest{Model: "m", Messages: providerMessages}, nil); err != nil {
+				t.Fatalf("Complete failed: %v", err)
+			}
+
+			// Only compare the parameters, not the messages.
+			params := map[string]any{}
+			for _, k := range []string{"temperature", "max_tokens", "seed", "stop", "stop_sequences", "options", "user"} {
+				if v, ok := got[k]; ok {
+					params[k] = v
+				}
+			}
+			data, _ := json.Marshal(params)
+			if string(data) != tt.want {
+				t.Errorf("unexpected parameters\n got %s\nwant %s", data, tt.want)
+			}
+		})
+	}
+}
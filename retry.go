@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	DefaultRetryMaxAttempts = 5
+	DefaultRetryMaxDelay    = time.Minute
+	retryBaseDelay          = time.Second
+)
+
+// retryPolicy controls how failed API requests are retried. A timeout
+// limits each attempt.
+type retryPolicy struct {
+	maxAttempts int
+	maxDelay    time.Duration
+	timeout     time.Duration
+}
+
+func newRetryPolicy(s Settings) (retryPolicy, error) {
+	p := retryPolicy{maxAttempts: s.RetryMaxAttempts, maxDelay: DefaultRetryMaxDelay}
+	if p.maxAttempts <= 0 {
+		p.maxAttempts = DefaultRetryMaxAttempts
+	}
+	if s.RetryMaxDelay != "" {
+		d, err := time.ParseDuration(s.RetryMaxDelay)
+		if err != nil {
+			return p, fmt.Errorf("invalid retry_max_delay: %w", err)
+		}
+		p.maxDelay = d
+	}
+	if s.RequestTimeout != "" {
+		d, err := time.ParseDuration(s.RequestTimeout)
+		if err != nil {
+			return p, fmt.Errorf("invalid request_timeout: %w", err)
+		}
+		p.timeout = d
+	}
+	return p, nil
+}
+
+// backoff returns the delay before the given retry (starting at 1): an
+// exponentially growing delay with jitter, capped at maxDelay.
+func (p retryPolicy) backoff(retry int) time.Duration {
+	d := retryBaseDelay << min(retry-1, 16)
+	if d > p.maxDelay {
+		d = p.maxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// withRetry calls fn until it succeeds, fails with an error that is not
+// worth retrying, or runs out of attempts. An attempt that exceeds the
+// request timeout is not retried, so the next model can be tried.
+func (a *Agent) withRetry(ctx context.Context, fn func(ctx context.Context) error) error {
+	policy, err := newRetryPolicy(a.Config.Settings)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := a.attempt(ctx, policy.timeout, fn)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if errors.Is(err, context.DeadlineExceeded) && policy.timeout > 0 {
+			return fmt.Errorf("no answer within request_timeout (%s): %w", policy.timeout, err)
+		}
+
+		retryable, reason := classifyError(err)
+		if !retryable {
+			return explainError(err)
+		}
+		if attempt >= policy.maxAttempts {
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		delay := policy.backoff(attempt)
+		if after, ok := a.retryAfter.take(); ok {
+			delay = min(after, policy.maxDelay)
+		}
+		fmt.Fprintf(os.Stderr, "API request failed (%s), retrying in %s (attempt %d/%d)...\n",
+			reason, delay.Round(100*time.Millisecond), attempt+1, policy.maxAttempts)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (a *Agent) attempt(ctx context.Context, timeout time.Duration, fn func(ctx context.Context) error) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return fn(ctx)
+}
+
+// classifyError reports whether a failed request is worth retrying, with a
+// short description for the retry notice.
+func classifyError(err error) (bool, string) {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false, ""
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Type == "insufficient_quota" || apiErr.Code == "insufficient_quota" {
+			return false, ""
+		}
+		return retryableStatus(apiErr.HTTPStatusCode), statusText(apiErr.HTTPStatusCode)
+	}
+
+	var provErr *APIError
+	if errors.As(err, &provErr) {
+		return retryableStatus(provErr.StatusCode), statusText(provErr.StatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		if reqErr.HTTPStatusCode == 0 {
+			return true, "network error"
+		}
+		return retryableStatus(reqErr.HTTPStatusCode), statusText(reqErr.HTTPStatusCode)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true, "network error"
+	}
+	return false, ""
+}
+
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return true
+	}
+	return code >= 500
+}
+
+func statusText(code int) string {
+	return fmt.Sprintf("%d %s", code, http.StatusText(code))
+}
+
+// explainError adds a hint to errors that retrying cannot fix.
+func explainError(err error) error {
+	code := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	var provErr *APIError
+	switch {
+	case errors.As(err, &apiErr):
+		code = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		code = reqErr.HTTPStatusCode
+	case errors.As(err, &provErr):
+		code = provErr.StatusCode
+	}
+
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return fmt.Errorf("authentication failed, check api_key: %w", err)
+	case code == http.StatusBadRequest && isContextLengthError(err):
+		return fmt.Errorf("the session no longer fits in the model's context window; run `saa session compact` or set context_budget: %w", err)
+	}
+	return err
+}
+
+func isContextLengthError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) && apiErr.Code == "context_length_exceeded" {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "context length") || strings.Contains(msg, "context_length") ||
+		strings.Contains(msg, "context window") || strings.Contains(msg, "too many tokens") ||
+		strings.Contains(msg, "prompt is too long")
+}
+
+// retryAfterTransport remembers the Retry-After header of the last
+// throttled response, which go-openai does not expose in its errors.
+type retryAfterTransport struct {
+	base http.RoundTripper
+
+	mu    sync.Mutex
+	after time.Duration
+	set   bool
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	resp, err := base.RoundTrip(req)
+	if err != nil || resp.StatusCode < 400 {
+		return resp, err
+	}
+
+	if d, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+		t.mu.Lock()
+		t.after, t.set = d, true
+		t.mu.Unlock()
+	}
+	return resp, err
+}
+
+// take returns and clears the last recorded Retry-After delay.
+func (t *retryAfterTransport) take() (time.Duration, bool) {
+	if t == nil {
+		return 0, false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d, ok := t.after, t.set
+	t.after, t.set = 0, false
+	return d, ok
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an
+// HTTP date.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(secs, 0)) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(t.Sub(now), 0), true
+	}
+	return 0, false
+}
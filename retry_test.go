@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err       error
+		retryable bool
+	}{
+		{&openai.APIError{HTTPStatusCode: 429}, true},
+		{&openai.APIError{HTTPStatusCode: 502}, true},
+		{&openai.APIError{HTTPStatusCode: 429, Type: "insufficient_quota"}, false},
+		{&openai.APIError{HTTPStatusCode: 400}, false},
+		{&openai.APIError{HTTPStatusCode: 401}, false},
+		{&openai.RequestError{HTTPStatusCode: 503}, true},
+		{&openai.RequestError{HTTPStatusCode: 404}, false},
+		{fmt.Errorf("read: %w", &net.OpError{Op: "read", Err: errors.New("connection reset by peer")}), true},
+		{context.Canceled, false},
+		{errors.New("something else"), false},
+	}
+	for _, tt := range tests {
+		if retryable, _ := classifyError(tt.err); retryable != tt.retryable {
+			t.Errorf("classifyError(%v) = %v, expected %v", tt.err, retryable, tt.retryable)
+		}
+	}
+}
+
+func TestExplainError(t *testing.T) {
+	err := explainError(&openai.APIError{HTTPStatusCode: 400, Code: "context_length_exceeded", Message: "too long"})
+	if !strings.Contains(err.Error(), "saa session compact") {
+		t.Errorf("expected a hint about compaction, got %v", err)
+	}
+	err = explainError(&openai.APIError{HTTPStatusCode: 401, Message: "bad key"})
+	if !strings.Contains(err.Error(), "api_key") {
+		t.Errorf("expected a hint about the API key, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if d, ok := parseRetryAfter("3", now); !ok || d != 3*time.Second {
+		t.Errorf("expected 3s, got %v %v", d, ok)
+	}
+	if d, ok := parseRetryAfter("Mon, 01 Jan 2024 00:00:10 GMT", now); !ok || d != 10*time.Second {
+		t.Errorf("expected 10s, got %v %v", d, ok)
+	}
+	if _, ok := parseRetryAfter("", now); ok {
+		t.Errorf("expected no delay for an empty header")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := retryPolicy{maxAttempts: 5, maxDelay: 3 * time.Second}
+	for retry := 1; retry <= 10; retry++ {
+		d := p.backoff(retry)
+		if d > p.maxDelay {
+			t.Errorf("backoff(%d) = %v exceeds the maximum", retry, d)
+		}
+	}
+	if d := p.backoff(1); d < retryBaseDelay/2 || d > retryBaseDelay {
+		t.Errorf("unexpected first backoff %v", d)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"slow down","type":"rate_limit"}}`)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprint(w, `<html>bad gateway</html>`)
+		default:
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"}}]}`)
+		}
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "saa-test-retry")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		SaaDir:   filepath.Join(tmpDir, ".saa"),
+		Settings: Settings{APIURL: server.URL, Model: "test", RetryMaxDelay: "10ms"},
+	}
+	agent := NewAgent(config, NewSession(config))
+
+	resp, _, err := agent.complete(context.Background(), CompletionRequest{}, PurposeMain, display{})
+	if err != nil {
+		t.Fatalf("complete failed: %v", err)
+	}
+	if calls != 3 || resp.Message.Content != "ok" {
+		t.Errorf("expected success on the third attempt, got %d calls", calls)
+	}
+
+	calls = 0
+	config.Settings.RetryMaxAttempts = 2
+	if _, _, err := agent.complete(context.Background(), CompletionRequest{}, PurposeMain, display{}); err == nil {
+		t.Errorf("expected an error after 2 attempts")
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 calls, got %d", calls)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Purposes of model requests, which routing can send to different models.
+const (
+	PurposeMain       = ""
+	PurposeSummary    = "summary"
+	PurposeCompaction = "compaction"
+	PurposeTitle      = "title"
+)
+
+// ModelEndpoint is a model and the API serving it. Empty fields are taken
+// from the top-level settings, except that another provider gets its own
+// default api_url.
+type ModelEndpoint struct {
+	Model    string `mapstructure:"model" json:"model"`
+	Provider string `mapstructure:"provider" json:"provider,omitempty"`
+	APIURL   string `mapstructure:"api_url" json:"api_url,omitempty"`
+	APIKey   string `mapstructure:"api_key" json:"api_key,omitempty"`
+}
+
+// RoutingSettings sends some requests to other models than the main one.
+// Models named here are looked up in fallbacks for their endpoint.
+type RoutingSettings struct {
+	Summary    string `mapstructure:"summary" json:"summary,omitempty"`
+	Compaction string `mapstructure:"compaction" json:"compaction,omitempty"`
+	Title      string `mapstructure:"title" json:"title,omitempty"`
+
+	// Escalate is used for the rest of a task once EscalateAfter commands
+	// of it have failed.
+	Escalate      string `mapstructure:"escalate" json:"escalate,omitempty"`
+	EscalateAfter int    `mapstructure:"escalate_after" json:"escalate_after,omitempty"`
+}
+
+// endpoint fills the empty fields of ep from s.
+func (s Settings) endpoint(ep ModelEndpoint) ModelEndpoint {
+	if ep.Provider == "" {
+		ep.Provider = s.Provider
+	}
+	if ep.Provider == s.Provider {
+		if ep.APIURL == "" {
+			ep.APIURL = s.APIURL
+		}
+		if ep.APIKey == "" {
+			ep.APIKey = s.APIKey
+		}
+	}
+	return ep
+}
+
+// modelChain returns the endpoints to try, in order, for a request: the
+// routed model if any, then the main model and its fallbacks.
+func (a *Agent) modelChain(purpose string) []ModelEndpoint {
+	s := a.Config.Settings
+	var chain []ModelEndpoint
+	for _, ep := range append([]ModelEndpoint{{Model: s.Model}}, s.Fallbacks...) {
+		chain = append(chain, s.endpoint(ep))
+	}
+
+	routed := ""
+	switch purpose {
+	case PurposeMain:
+		if s.Routing.EscalateAfter > 0 && a.failedCommands >= s.Routing.EscalateAfter {
+			routed = s.Routing.Escalate
+		}
+	case PurposeSummary:
+		routed = s.Routing.Summary
+	case PurposeCompaction:
+		routed = s.Routing.Compaction
+	case PurposeTitle:
+		routed = s.Routing.Title
+	}
+	if routed == "" {
+		return chain
+	}
+
+	i := slices.IndexFunc(chain, func(ep ModelEndpoint) bool { return ep.Model == routed })
+	if i < 0 {
+		return append([]ModelEndpoint{s.endpoint(ModelEndpoint{Model: routed})}, chain...)
+	}
+	ep := chain[i]
+	return append([]ModelEndpoint{ep}, slices.Delete(chain, i, i+1)...)
+}
+
+// providerFor returns the provider of an endpoint, creating it on first use.
+func (a *Agent) providerFor(ep ModelEndpoint) Provider {
+	s := a.Config.Settings
+	if ep.Provider == s.Provider && ep.APIURL == s.APIURL && ep.APIKey == s.APIKey {
+		return a.Provider
+	}
+	if p, ok := a.providers[ep]; ok {
+		return p
+	}
+	s.Provider, s.APIURL, s.APIKey = ep.Provider, ep.APIURL, ep.APIKey
+	p := NewProvider(s, a.httpClient)
+	if a.providers == nil {
+		a.providers = map[ModelEndpoint]Provider{}
+	}
+	a.providers[ep] = p
+	return p
+}
+
+// countFailure notes a failed command, announcing the switch to the
+// escalation model when it is reached.
+func (a *Agent) countFailure(exitCode *int) {
+	if exitCode == nil || *exitCode == 0 {
+		return
+	}
+	a.failedCommands++
+	r := a.Config.Settings.Routing
+	if r.Escalate != "" && a.failedCommands == r.EscalateAfter {
+		fmt.Fprintf(os.Stderr, "%d commands failed, switching to %s.\n", a.failedCommands, r.Escalate)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestModelFallback(t *testing.T) {
+	primaryCalls := 0
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primaryCalls++
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"message":"model not found","type":"invalid_request_error"}}`)
+	}))
+	defer primary.Close()
+
+	var models []string
+	fallback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if len(models) == 0 {
+			models = append(models, "call")
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[
+				{"id":"1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"true\"}"}}
+			]}}]}`)
+			return
+		}
+		models = append(models, "answer")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"done"}}]}`)
+	}))
+	defer fallback.Close()
+
+	root := t.TempDir()
+	config := &Config{
+		ProjectRoot: root,
+		SaaDir:      filepath.Join(root, ".saa"),
+		Settings: Settings{
+			APIURL:    primary.URL,
+			Model:     "big",
+			Fallbacks: []ModelEndpoint{{Model: "small", APIURL: fallback.URL}},
+		},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+	if err := agent.Run(context.Background(), "go"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	// The failed model is skipped for the rest of the run.
+	if primaryCalls != 1 || len(models) != 2 {
+		t.Errorf("expected 1 call to the primary and 2 to the fallback, got %d and %d", primaryCalls, len(models))
+	}
+	for _, m := range session.Messages {
+		if m.Role == RoleAssistant && m.Model != "small" {
+			t.Errorf("expected assistant messages from small, got %+v", m)
+		}
+	}
+	stats, err := ReadSessionStats(session.LogFile)
+	if err != nil {
+		t.Fatalf("ReadSessionStats failed: %v", err)
+	}
+	if len(stats.Events) != 2 || stats.Events[0].Model != "small" {
+		t.Errorf("unexpected usage events %+v", stats.Events)
+	}
+}
+
+func TestModelChain(t *testing.T) {
+	config := &Config{Settings: Settings{
+		APIURL:   "http://main",
+		APIKey:   "key",
+		Model:    "strong",
+		Provider: ProviderOpenAI,
+		Fallbacks: []ModelEndpoint{
+			{Model: "backup"},
+			{Model: "claude", Provider: ProviderAnthropic, APIKey: "other"},
+		},
+		Routing: RoutingSettings{Title: "claude", Compaction: "cheap", Escalate: "claude", EscalateAfter: 2},
+	}}
+	agent := &Agent{Config: config}
+
+	names := func(chain []ModelEndpoint) string {
+		var out []string
+		for _, ep := range chain {
+			out = append(out, ep.Model)
+		}
+		return strings.Join(out, " ")
+	}
+
+	main := agent.modelChain(PurposeMain)
+	if got := names(main); got != "strong backup claude" {
+		t.Errorf("main chain = %s", got)
+	}
+	if main[1].APIURL != "http://main" || main[1].APIKey != "key" {
+		t.Errorf("expected the fallback to inherit the endpoint, got %+v", main[1])
+	}
+	if main[2].APIURL != "" || main[2].APIKey != "other" {
+		t.Errorf("expected another provider to keep its own endpoint, got %+v", main[2])
+	}
+	if got := names(agent.modelChain(PurposeTitle)); got != "claude strong backup" {
+		t.Errorf("title chain = %s", got)
+	}
+	if got := names(agent.modelChain(PurposeCompaction)); got != "cheap strong backup claude" {
+		t.Errorf("compaction chain = %s", got)
+	}
+	if got := names(agent.modelChain(PurposeSummary)); got != "strong backup claude" {
+		t.Errorf("summary chain = %s", got)
+	}
+
+	one := 1
+	agent.countFailure(&one)
+	agent.countFailure(nil)
+	if got := names(agent.modelChain(PurposeMain)); got != "strong backup claude" {
+		t.Errorf("escalated too early: %s", got)
+	}
+	agent.countFailure(&one)
+	if got := names(agent.modelChain(PurposeMain)); got != "claude strong backup" {
+		t.Errorf("escalated chain = %s", got)
+	}
+}
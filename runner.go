@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Runner decides how the bash process for a tool call is started.
+type Runner interface {
+	// Wrap returns the command line that runs args inside the runner's
+	// environment, with workDir as the working directory.
+	Wrap(args []string, workDir string) ([]string, error)
+}
+
+type SandboxSettings struct {
+	Runner     string   `mapstructure:"runner" json:"runner,omitempty"`
+	UnshareNet bool     `mapstructure:"unshare_net" json:"unshare_net,omitempty"`
+	Writable   []string `mapstructure:"writable" json:"writable,omitempty"`
+	Prefix     []string `mapstructure:"prefix" json:"prefix,omitempty"`
+}
+
+// NewRunner builds the runner selected by config.Settings.Sandbox.
+func NewRunner(config *Config) (Runner, error) {
+	sandbox := config.Settings.Sandbox
+	switch sandbox.Runner {
+	case "", "plain":
+		return PlainRunner{}, nil
+	case "bwrap":
+		if _, err := exec.LookPath("bwrap"); err != nil {
+			return nil, fmt.Errorf("sandbox runner bwrap: %w", err)
+		}
+		var writable []string
+		for _, p := range sandbox.Writable {
+			if !filepath.IsAbs(p) {
+				p = filepath.Join(config.ProjectRoot, p)
+			}
+			writable = append(writable, p)
+		}
+		return BwrapRunner{
+			ProjectRoot: config.ProjectRoot,
+			Writable:    writable,
+			UnshareNet:  sandbox.UnshareNet,
+		}, nil
+	case "prefix":
+		if len(sandbox.Prefix) == 0 {
+			return nil, fmt.Errorf("sandbox runner prefix needs a prefix command")
+		}
+		return PrefixRunner{
+			Template:    sandbox.Prefix,
+			ProjectRoot: config.ProjectRoot,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown sandbox runner: %q", sandbox.Runner)
+}
+
+// PlainRunner runs bash directly, without any isolation.
+type PlainRunner struct{}
+
+func (PlainRunner) Wrap(args []string, workDir string) ([]string, error) {
+	return args, nil
+}
+
+// BwrapRunner runs bash under bubblewrap with the project root (and any
+// extra Writable paths) mounted read-write and everything else read-only.
+// The .saa directory stays read-only, so commands cannot change the policy
+// and sandbox settings of later runs.
+type BwrapRunner struct {
+	ProjectRoot string
+	Writable    []string
+	UnshareNet  bool
+}
+
+func (r BwrapRunner) Wrap(args []string, workDir string) ([]string, error) {
+	root, err := filepath.Abs(r.ProjectRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	wrapped := []string{
+		"bwrap",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--bind", root, root,
+		"--ro-bind-try", filepath.Join(root, ".saa"), filepath.Join(root, ".saa"),
+	}
+	for _, p := range r.Writable {
+		wrapped = append(wrapped, "--bind", p, p)
+	}
+	if r.UnshareNet {
+		wrapped = append(wrapped, "--unshare-net")
+	}
+	// No --new-session: the persistent shell interrupts a command through
+	// its process group, which the command would leave.
+	wrapped = append(wrapped,
+		"--unshare-pid",
+		"--die-with-parent",
+		"--chdir", workDir,
+		"--",
+	)
+	return append(wrapped, args...), nil
+}
+
+// PrefixRunner runs bash through a user supplied command such as firejail
+// or `docker exec -i`. The placeholders {root} and {workdir} are expanded in
+// every element of Template. An element that is exactly {command} is
+// replaced by the bash command line; without one it is appended.
+type PrefixRunner struct {
+	Template    []string
+	ProjectRoot string
+}
+
+func (r PrefixRunner) Wrap(args []string, workDir string) ([]string, error) {
+	replacer := strings.NewReplacer("{root}", r.ProjectRoot, "{workdir}", workDir)
+
+	var wrapped []string
+	inserted := false
+	for _, t := range r.Template {
+		if t == "{command}" {
+			wrapped = append(wrapped, args...)
+			inserted = true
+			continue
+		}
+		wrapped = append(wrapped, replacer.Replace(t))
+	}
+	if !inserted {
+		wrapped = append(wrapped, args...)
+	}
+	return wrapped, nil
+}
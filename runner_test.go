@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"reflect"
+	"testing"
+)
+
+func TestBwrapRunnerWrap(t *testing.T) {
+	runner := BwrapRunner{
+		ProjectRoot: "/work/project",
+		Writable:    []string{"/tmp"},
+		UnshareNet:  true,
+	}
+
+	args, err := runner.Wrap([]string{"/bin/bash", "script.sh"}, "/work/project/sub")
+	if err != nil {
+		t.Fatalf("Wrap failed: %v", err)
+	}
+
+	expected := []string{
+		"bwrap",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--bind", "/work/project", "/work/project",
+		"--ro-bind-try", "/work/project/.saa", "/work/project/.saa",
+		"--bind", "/tmp", "/tmp",
+		"--unshare-net",
+		"--unshare-pid",
+		"--die-with-parent",
+		"--chdir", "/work/project/sub",
+		"--",
+		"/bin/bash", "script.sh",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("unexpected bwrap args:\n%q\nexpected:\n%q", args, expected)
+	}
+}
+
+func TestPrefixRunnerWrap(t *testing.T) {
+	tests := []struct {
+		template []string
+		expected []string
+	}{
+		{
+			template: []string{"firejail", "--whitelist={root}"},
+			expected: []string{"firejail", "--whitelist=/p", "/bin/bash", "s.sh"},
+		},
+		{
+			template: []string{"docker", "exec", "-i", "-w", "{workdir}", "box", "{command}", "--extra"},
+			expected: []string{"docker", "exec", "-i", "-w", "/p/sub", "box", "/bin/bash", "s.sh", "--extra"},
+		},
+	}
+
+	for _, tt := range tests {
+		runner := PrefixRunner{Template: tt.template, ProjectRoot: "/p"}
+		args, err := runner.Wrap([]string{"/bin/bash", "s.sh"}, "/p/sub")
+		if err != nil {
+			t.Fatalf("Wrap failed: %v", err)
+		}
+		if !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("expected %q, got %q", tt.expected, args)
+		}
+	}
+}
+
+func TestNewRunner(t *testing.T) {
+	config := &Config{ProjectRoot: "/p"}
+
+	runner, err := NewRunner(config)
+	if err != nil {
+		t.Fatalf("NewRunner failed: %v", err)
+	}
+	if _, ok := runner.(PlainRunner); !ok {
+		t.Errorf("expected PlainRunner by default, got %T", runner)
+	}
+
+	config.Settings.Sandbox = SandboxSettings{Runner: "prefix"}
+	if _, err := NewRunner(config); err == nil {
+		t.Error("expected error for prefix runner without prefix")
+	}
+
+	config.Settings.Sandbox = SandboxSettings{Runner: "chroot"}
+	if _, err := NewRunner(config); err == nil {
+		t.Error("expected error for unknown runner")
+	}
+}
+
+func TestExecuteBashWithRunner(t *testing.T) {
+	tmpDir := t.TempDir()
+	runner := PrefixRunner{Template: []string{"env", "SAA_SANDBOXED=yes"}}
+	res, err := ExecuteBash(context.Background(), runner, "echo $SAA_SANDBOXED", tmpDir, 0)
+	if err != nil {
+		t.Fatalf("ExecuteBash failed: %v", err)
+	}
+	if res.Stdout != "yes\n" {
+		t.Errorf("expected command to run through the prefix, got %q", res.Stdout)
+	}
+
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("bwrap not installed")
+	}
+	bwrap := BwrapRunner{ProjectRoot: tmpDir}
+	res, err = ExecuteBash(context.Background(), bwrap, "touch inside && touch /usr/outside", tmpDir, 0)
+	if err != nil {
+		t.Fatalf("ExecuteBash failed: %v", err)
+	}
+	if res.ExitCode == 0 {
+		t.Errorf("expected write outside the project root to fail")
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// searchDoc is the searchable text of a message: its content, reasoning and
+// tool call commands.
+type searchDoc struct {
+	Turn int    `json:"turn"`
+	Role string `json:"role"`
+	Text string `json:"text"`
+}
+
+// searchLogRef is an output log referenced by a tool result in turn Turn.
+type searchLogRef struct {
+	Turn   int    `json:"turn"`
+	Stream string `json:"stream"`
+	ID     string `json:"id"`
+}
+
+// searchFile is the extracted text of one session file. Size and ModTime
+// tell whether an indexed copy is still current.
+type searchFile struct {
+	Size    int64          `json:"size"`
+	ModTime time.Time      `json:"mod_time"`
+	Docs    []searchDoc    `json:"docs"`
+	LogRefs []searchLogRef `json:"log_refs,omitempty"`
+}
+
+// extractSearchFile reads the searchable text of a session file. Turns
+// count user messages, so the system prompt is in turn 0.
+func extractSearchFile(path string) (*searchFile, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	sf := &searchFile{Size: fi.Size(), ModTime: fi.ModTime()}
+
+	turn := 0
+	err = scanSessionFile(path, func(typ string, line []byte) error {
+		if typ != "" {
+			return nil
+		}
+		msg, err := decodeMessage(line)
+		if err != nil {
+			return nil
+		}
+		if msg.Role == RoleUser {
+			turn++
+		}
+
+		text := msg.Content
+		if msg.ReasoningContent != "" {
+			text += "\n" + msg.ReasoningContent
+		}
+		for _, tc := range msg.ToolCalls {
+			text += "\n" + toolCallCommand(tc)
+		}
+		if text = strings.TrimSpace(text); text != "" {
+			sf.Docs = append(sf.Docs, searchDoc{Turn: turn, Role: msg.Role, Text: text})
+		}
+
+		if msg.Role == RoleTool {
+			for _, m := range truncatedLogRe.FindAllStringSubmatch(msg.Content, -1) {
+				sf.LogRefs = append(sf.LogRefs, searchLogRef{Turn: turn, Stream: m[1], ID: m[2]})
+			}
+		}
+		return nil
+	})
+	return sf, err
+}
+
+// searchIndex caches the extracted text of session files on disk. Logs are
+// read directly, as caching them would copy every output into the index.
+// Without a path nothing is cached.
+type searchIndex struct {
+	Files map[string]*searchFile `json:"files"`
+
+	path  string
+	dirty bool
+}
+
+// searchIndexPath returns where the search index of a project is kept.
+func searchIndexPath(config *Config) string {
+	return filepath.Join(config.SaaDir, "search-index.json")
+}
+
+func loadSearchIndex(path string) *searchIndex {
+	idx := &searchIndex{path: path}
+	if data, err := os.ReadFile(path); path != "" && err == nil {
+		json.Unmarshal(data, idx)
+	}
+	if idx.Files == nil {
+		idx.Files = map[string]*searchFile{}
+	}
+	return idx
+}
+
+// file returns the indexed text of a session file, re-reading it if it
+// changed since it was indexed.
+func (idx *searchIndex) file(dir, name string) (*searchFile, error) {
+	path := filepath.Join(dir, name)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if sf, ok := idx.Files[name]; ok && sf.Size == fi.Size() && sf.ModTime.Equal(fi.ModTime()) {
+		return sf, nil
+	}
+
+	sf, err := extractSearchFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if idx.path != "" {
+		idx.Files[name] = sf
+		idx.dirty = true
+	}
+	return sf, nil
+}
+
+// prune drops sessions that no longer exist.
+func (idx *searchIndex) prune(names []string) {
+	for name := range idx.Files {
+		if !slices.Contains(names, name) {
+			delete(idx.Files, name)
+			idx.dirty = true
+		}
+	}
+}
+
+func (idx *searchIndex) save() error {
+	if !idx.dirty {
+		return nil
+	}
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(idx.path, data, 0644)
+}
+
+// SearchOptions selects what a search matches.
+type SearchOptions struct {
+	Query string
+	Regex bool
+	Roles []string
+	Since time.Time
+	Until time.Time
+	Logs  bool
+	Limit int
+}
+
+// SearchResult is one matching message or log.
+type SearchResult struct {
+	File    string
+	Turn    int
+	Role    string
+	Snippet string
+	Match   [2]int // position of the match in Snippet
+}
+
+func (o SearchOptions) pattern() (*regexp.Regexp, error) {
+	if o.Regex {
+		return regexp.Compile(o.Query)
+	}
+	return regexp.Compile("(?i)" + regexp.QuoteMeta(o.Query))
+}
+
+func (o SearchOptions) wantRole(role string) bool {
+	return len(o.Roles) == 0 || slices.Contains(o.Roles, role)
+}
+
+// sessionTime returns the creation time encoded in a session file name.
+func sessionTime(name string) (time.Time, bool) {
+	if len(name) < 15 {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("20060102-150405", name[:15], time.Local)
+	return t, err == nil
+}
+
+// parseDateArg parses a date given as YYYY-MM-DD, YYYY-MM-DD HH:MM, or an
+// age such as 7d or 12h counted back from now. With endOfDay, a plain date
+// means the end of that day, so it can be used as an inclusive upper bound.
+func parseDateArg(s string, now time.Time, endOfDay bool) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "20060102"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			if endOfDay {
+				t = t.AddDate(0, 0, 1)
+			}
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local); err == nil {
+		return t, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid date: %s (use YYYY-MM-DD or an age like 7d)", s)
+}
+
+// Search looks for opts.Query in all session files, newest first. With
+// useIndex, extracted text is cached in the search index under .saa.
+func (s *Session) Search(opts SearchOptions, useIndex bool) ([]SearchResult, error) {
+	re, err := opts.pattern()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	idx := loadSearchIndex("")
+	if useIndex {
+		idx = loadSearchIndex(searchIndexPath(s.Config))
+	}
+
+	var results []SearchResult
+	add := func(r SearchResult) bool {
+		results = append(results, r)
+		return opts.Limit > 0 && len(results) >= opts.Limit
+	}
+
+search:
+	for _, name := range files {
+		if t, ok := sessionTime(name); ok {
+			if !opts.Since.IsZero() && t.Before(opts.Since) {
+				continue
+			}
+			if !opts.Until.IsZero() && !t.Before(opts.Until) {
+				continue
+			}
+		}
+
+		sf, err := idx.file(s.SessionDir, name)
+		if err != nil {
+			continue
+		}
+
+		for _, doc := range sf.Docs {
+			if !opts.wantRole(doc.Role) {
+				continue
+			}
+			if loc := re.FindStringIndex(doc.Text); loc != nil {
+				snippet, match := makeSnippet(doc.Text, loc)
+				if add(SearchResult{File: name, Turn: doc.Turn, Role: doc.Role, Snippet: snippet, Match: match}) {
+					break search
+				}
+			}
+		}
+
+		if !opts.Logs || !opts.wantRole(RoleTool) {
+			continue
+		}
+		for _, ref := range sf.LogRefs {
+			data, err := os.ReadFile(filepath.Join(s.SessionDir, fmt.Sprintf("%s.%s.log", ref.ID, ref.Stream)))
+			if err != nil {
+				continue
+			}
+			if loc := re.FindIndex(data); loc != nil {
+				snippet, match := makeSnippet(string(data), loc)
+				if add(SearchResult{File: name, Turn: ref.Turn, Role: ref.Stream + " log", Snippet: snippet, Match: match}) {
+					break search
+				}
+			}
+		}
+	}
+
+	if useIndex {
+		idx.prune(files)
+	}
+	return results, idx.save()
+}
+
+// snippetContext is how many bytes around a match are shown.
+const snippetContext = 40
+
+// makeSnippet cuts the text around loc onto a single line and returns it
+// with the position of the match in it.
+func makeSnippet(text string, loc []int) (string, [2]int) {
+	start := max(loc[0]-snippetContext, 0)
+	end := min(loc[1]+snippetContext, len(text))
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = "..."
+	}
+	if end < len(text) {
+		suffix = "..."
+	}
+
+	flatten := strings.NewReplacer("\n", " ", "\r", " ", "\t", " ")
+	before := prefix + flatten.Replace(text[start:loc[0]])
+	match := flatten.Replace(text[loc[0]:loc[1]])
+	after := flatten.Replace(text[loc[1]:end]) + suffix
+	return before + match + after, [2]int{len(before), len(before) + len(match)}
+}
+
+// printSearchResults writes one line per result, highlighting the match
+// when color is set.
+func printSearchResults(w io.Writer, results []SearchResult, color bool) {
+	for _, r := range results {
+		snippet := r.Snippet
+		if color {
+			snippet = snippet[:r.Match[0]] + ansiBold + ansiRed + snippet[r.Match[0]:r.Match[1]] + ansiReset + snippet[r.Match[1]:]
+		}
+		fmt.Fprintf(w, "%s:%d [%s] %s\n", r.File, r.Turn, r.Role, snippet)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearch(t *testing.T) {
+	session := newTranscriptSession(t)
+	for _, msg := range []Message{
+		{Role: RoleUser, Content: "Fix the Migration script"},
+		{Role: RoleAssistant, Content: "The migration is fixed."},
+	} {
+		if err := session.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+	name := filepath.Base(session.LogFile)
+
+	results, err := session.Search(SearchOptions{Query: "migration"}, false)
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 matches, got %+v", results)
+	}
+	r := results[0]
+	if r.File != name || r.Turn != 2 || r.Role != "user" || r.Snippet[r.Match[0]:r.Match[1]] != "Migration" {
+		t.Errorf("unexpected result %+v", r)
+	}
+
+	results, _ = session.Search(SearchOptions{Query: "migration", Roles: []string{"assistant"}}, false)
+	if len(results) != 1 || results[0].Role != "assistant" {
+		t.Errorf("expected only the assistant match, got %+v", results)
+	}
+
+	results, _ = session.Search(SearchOptions{Query: `Mig\w+`, Regex: true}, false)
+	if len(results) != 1 {
+		t.Errorf("expected a case-sensitive regex match, got %+v", results)
+	}
+
+	// Only the full log contains the end of the long output.
+	results, _ = session.Search(SearchOptions{Query: "x<b>"}, false)
+	if len(results) != 0 {
+		t.Errorf("expected no match without logs, got %+v", results)
+	}
+	results, _ = session.Search(SearchOptions{Query: "x<b>", Logs: true}, false)
+	if len(results) != 1 || results[0].Role != "stdout log" || results[0].Turn != 1 {
+		t.Errorf("expected a match in the stdout log, got %+v", results)
+	}
+
+	// Logs are searched but not copied into the index.
+	results, _ = session.Search(SearchOptions{Query: "x<b>", Logs: true}, true)
+	if len(results) != 1 {
+		t.Errorf("expected a match in the stdout log with the index, got %+v", results)
+	}
+	if data, err := os.ReadFile(filepath.Join(session.Config.SaaDir, "search-index.json")); err != nil || strings.Contains(string(data), `"logs"`) {
+		t.Errorf("expected an index without logs, got %s (%v)", data, err)
+	}
+
+	future := time.Now().Add(time.Hour)
+	results, _ = session.Search(SearchOptions{Query: "migration", Since: future}, false)
+	if len(results) != 0 {
+		t.Errorf("expected no sessions after %v, got %+v", future, results)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	session := newTestSession(t, "deploy the app")
+	indexFile := filepath.Join(session.Config.SaaDir, "search-index.json")
+
+	results, err := session.Search(SearchOptions{Query: "deploy"}, true)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("Search failed: %v %+v", err, results)
+	}
+	if _, err := os.Stat(indexFile); err != nil {
+		t.Fatalf("expected an index file: %v", err)
+	}
+
+	// Changed sessions are re-read.
+	session.AddMessage(Message{Role: RoleUser, Content: "deploy again"})
+	results, _ = session.Search(SearchOptions{Query: "deploy"}, true)
+	if len(results) != 3 {
+		t.Errorf("expected 3 matches after the session changed, got %+v", results)
+	}
+
+	os.Remove(session.LogFile)
+	results, _ = session.Search(SearchOptions{Query: "deploy"}, true)
+	data, _ := os.ReadFile(indexFile)
+	if len(results) != 0 || strings.Contains(string(data), filepath.Base(session.LogFile)) {
+		t.Errorf("expected deleted sessions to be dropped from the index")
+	}
+}
+
+func TestMakeSnippet(t *testing.T) {
+	text := strings.Repeat("a", 100) + "\nneedle\n" + strings.Repeat("b", 100)
+	snippet, match := makeSnippet(text, []int{101, 107})
+	if snippet[match[0]:match[1]] != "needle" {
+		t.Errorf("unexpected match position in %q", snippet)
+	}
+	if !strings.HasPrefix(snippet, "...") || !strings.HasSuffix(snippet, "...") || strings.Contains(snippet, "\n") {
+		t.Errorf("unexpected snippet %q", snippet)
+	}
+}
+
+func TestParseDateArg(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.Local)
+	if d, err := parseDateArg("2024-05-01", now, false); err != nil || !d.Equal(time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("unexpected date %v %v", d, err)
+	}
+	if d, _ := parseDateArg("2024-05-01", now, true); !d.Equal(time.Date(2024, 5, 2, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("expected the end of the day, got %v", d)
+	}
+	if d, _ := parseDateArg("7d", now, false); !d.Equal(now.AddDate(0, 0, -7)) {
+		t.Errorf("unexpected age %v", d)
+	}
+	if _, err := parseDateArg("yesterday", now, false); err == nil {
+		t.Errorf("expected an error for an invalid date")
+	}
+}
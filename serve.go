@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Server exposes sessions and the agent over HTTP. Prompts are answered
+// with Server-Sent Events carrying the RunEvents of the turn.
+type Server struct {
+	config *Config
+	token  string
+	hosts  []string
+
+	mu       sync.Mutex
+	sessions map[string]*servedSession
+}
+
+// servedSession keeps the agent of a session between turns. mu is held
+// while a turn runs; cancel stops it.
+type servedSession struct {
+	mu     sync.Mutex
+	agent  *Agent
+	cancel context.CancelFunc
+}
+
+// NewServer returns a server for the project of config listening on addr.
+// With a token, every request must carry it as a bearer token.
+func NewServer(config *Config, token, addr string) *Server {
+	return &Server{config: config, token: token, hosts: allowedHosts(addr), sessions: map[string]*servedSession{}}
+}
+
+// allowedHosts returns the Host values that reach addr, or nil when addr
+// listens on every interface and any name may be used.
+func allowedHosts(addr string) []string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return []string{addr}
+	}
+	ip := net.ParseIP(host)
+	if host == "" || ip != nil && ip.IsUnspecified() {
+		return nil
+	}
+	hosts := []string{addr}
+	if host == "localhost" || ip != nil && ip.IsLoopback() {
+		hosts = append(hosts, net.JoinHostPort("localhost", port), net.JoinHostPort("127.0.0.1", port), net.JoinHostPort("::1", port))
+	}
+	return hosts
+}
+
+// Handler returns the routes of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sessions", s.handleList)
+	mux.HandleFunc("POST /sessions", s.handleCreate)
+	mux.HandleFunc("GET /sessions/current", s.handleCurrent)
+	mux.HandleFunc("PUT /sessions/current", s.handleSwitch)
+	mux.HandleFunc("GET /sessions/{session}", s.handleShow)
+	mux.HandleFunc("POST /sessions/{session}/prompt", s.handlePrompt)
+	mux.HandleFunc("POST /sessions/{session}/cancel", s.handleCancel)
+	mux.HandleFunc("GET /logs/{stream}/{id}", s.handleLog)
+	return s.guard(s.authorize(mux))
+}
+
+// guard rejects requests that web pages can make to the server: through
+// another host name (DNS rebinding) or from another origin. Bodies must be
+// JSON, which browsers do not send across origins without asking.
+func (s *Server) guard(next http.Handler) http.Handler {
+	allowed := func(host string) bool {
+		return s.hosts == nil || slices.Contains(s.hosts, host)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(r.Host) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("unexpected host %q", r.Host))
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !allowed(u.Host) || u.Host != r.Host && s.hosts == nil {
+				writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin request from %q", origin))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+var errNotJSON = errors.New("request body must be application/json")
+
+// decodeBody decodes the JSON body of r into v.
+func decodeBody(w http.ResponseWriter, r *http.Request, v any, usage string) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, errNotJSON)
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("expected %s", usage))
+		return false
+	}
+	return true
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+	if s.token == "" {
+		return next
+	}
+	want := []byte("Bearer " + s.token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing bearer token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) newSession() *Session {
+	return NewSession(s.config)
+}
+
+// resolve finds the session named in the request path.
+func (s *Server) resolve(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := s.newSession().Resolve(r.PathValue("session"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return "", false
+	}
+	return name, true
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	session := s.newSession()
+	files, err := session.List()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	current, _ := session.GetCurrentLogFile()
+	entries := make([]sessionEntry, 0, len(files))
+	for _, f := range files {
+		meta, _ := ReadMeta(filepath.Join(session.SessionDir, f))
+		entries = append(entries, sessionEntry{File: f, Current: f == current, SessionMeta: meta})
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (s *Server) handleCreate(w http.ResponseWriter, r *http.Request) {
+	session := s.newSession()
+	if err := session.NewSession(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"file": filepath.Base(session.LogFile)})
+}
+
+func (s *Server) handleCurrent(w http.ResponseWriter, r *http.Request) {
+	current, err := s.newSession().GetCurrentLogFile()
+	if err != nil {
+		writeError(w, http.StatusNotFound, errors.New("no current session"))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"file": current})
+}
+
+func (s *Server) handleSwitch(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Session string `json:"session"`
+	}
+	if !decodeBody(w, r, &body, `{"session": "..."}`) {
+		return
+	}
+	if body.Session == "" {
+		writeError(w, http.StatusBadRequest, errors.New(`expected {"session": "..."}`))
+		return
+	}
+	session := s.newSession()
+	name, err := session.Resolve(body.Session)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err := session.Switch(name); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"file": name})
+}
+
+func (s *Server) handleShow(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.resolve(w, r)
+	if !ok {
+		return
+	}
+	path := filepath.Join(s.newSession().SessionDir, name)
+	meta, _ := ReadMeta(path)
+	msgs, err := loadTranscript(path, false)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		File     string              `json:"file"`
+		Meta     SessionMeta         `json:"meta"`
+		Messages []transcriptMessage `json:"messages"`
+	}{name, meta, msgs})
+}
+
+// served returns the state kept for the session file name.
+func (s *Server) served(name string) *servedSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ss, ok := s.sessions[name]
+	if !ok {
+		ss = &servedSession{}
+		s.sessions[name] = ss
+	}
+	return ss
+}
+
+func (s *Server) handlePrompt(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.resolve(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Prompt string `json:"prompt"`
+	}
+	if !decodeBody(w, r, &body, `{"prompt": "..."}`) {
+		return
+	}
+	if strings.TrimSpace(body.Prompt) == "" {
+		writeError(w, http.StatusBadRequest, errors.New(`expected {"prompt": "..."}`))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	ss := s.served(name)
+	if !ss.mu.TryLock() {
+		writeError(w, http.StatusConflict, ErrSessionBusy)
+		return
+	}
+	defer ss.mu.Unlock()
+
+	session := s.newSession()
+	session.LogFile = filepath.Join(session.SessionDir, name)
+	if sessionBusy(session.LogFile) {
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: %s is in use by another saa process", ErrSessionBusy, name))
+		return
+	}
+	if ss.agent == nil {
+		ss.agent = NewAgent(s.config, session)
+		// Nobody can answer approval prompts, so "ask" rules deny.
+		ss.agent.NonInteractive = true
+	}
+	ss.agent.Session = session
+
+	// A client that goes away cancels the turn, like Ctrl-C does.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	s.mu.Lock()
+	ss.cancel = cancel
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		ss.cancel = nil
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var writeMu sync.Mutex
+	send := func(ev RunEvent) {
+		data, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		flusher.Flush()
+	}
+	ss.agent.Events = send
+	defer func() { ss.agent.Events = nil }()
+
+	err := ss.agent.Run(ctx, body.Prompt)
+	finished := finishEvent(err)
+	if finished.Reason == FinishError {
+		ss.agent.emit(RunEvent{Type: RunError, Error: finished.Error})
+	}
+	ss.agent.emit(finished)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	name, ok := s.resolve(w, r)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	var cancel context.CancelFunc
+	if ss, ok := s.sessions[name]; ok {
+		cancel = ss.cancel
+	}
+	s.mu.Unlock()
+
+	if cancel == nil {
+		writeError(w, http.StatusNotFound, errors.New("no turn is running in this session"))
+		return
+	}
+	cancel()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleLog(w http.ResponseWriter, r *http.Request) {
+	stream, id := r.PathValue("stream"), r.PathValue("id")
+	if !outputLogRe.MatchString(id + "." + stream + ".log") {
+		writeError(w, http.StatusBadRequest, errors.New("invalid log id"))
+		return
+	}
+	content, err := os.ReadFile(filepath.Join(s.newSession().SessionDir, id+"."+stream+".log"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("log not found: %s", id))
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write(content)
+}
+
+// CancelAll cancels every running turn.
+func (s *Server) CancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ss := range s.sessions {
+		if ss.cancel != nil {
+			ss.cancel()
+		}
+	}
+}
+
+// Close closes the agents of the server, stopping their persistent shells.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	sessions := slices.Collect(maps.Values(s.sessions))
+	s.mu.Unlock()
+
+	var errs []error
+	for _, ss := range sessions {
+		ss.mu.Lock()
+		if ss.agent != nil {
+			errs = append(errs, ss.agent.Close())
+		}
+		ss.mu.Unlock()
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"hello"}}]}`)
+	}))
+	defer api.Close()
+
+	root := t.TempDir()
+	config := &Config{
+		ProjectRoot: root,
+		SaaDir:      filepath.Join(root, ".saa"),
+		Settings:    Settings{APIURL: api.URL, Model: "test"},
+	}
+	ts := httptest.NewUnstartedServer(nil)
+	server := NewServer(config, "secret", ts.Listener.Addr().String())
+	defer server.Close()
+	ts.Config.Handler = server.Handler()
+	ts.Start()
+	defer ts.Close()
+
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %v", method, path, err)
+		}
+		return resp
+	}
+
+	resp, err := http.Get(ts.URL + "/sessions")
+	if err != nil {
+		t.Fatalf("GET /sessions failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("status without token = %d, want 401", resp.StatusCode)
+	}
+
+	resp = do("POST", "/sessions", "")
+	var created struct {
+		File string `json:"file"`
+	}
+	json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || created.File == "" {
+		t.Fatalf("POST /sessions = %d %+v", resp.StatusCode, created)
+	}
+
+	// Requests a web page could send are refused even with the token.
+	for _, tt := range []struct {
+		header, value string
+		status        int
+	}{
+		{"Content-Type", "text/plain", http.StatusUnsupportedMediaType},
+		{"Origin", "http://evil.example", http.StatusForbidden},
+		{"Host", "evil.example:8080", http.StatusForbidden},
+	} {
+		req, _ := http.NewRequest("POST", ts.URL+"/sessions/"+created.File+"/prompt", strings.NewReader(`{"prompt":"hi"}`))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(tt.header, tt.value)
+		if tt.header == "Host" {
+			req.Host = tt.value
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST with %s failed: %v", tt.header, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.status {
+			t.Errorf("POST with %s: %s = %d, want %d", tt.header, tt.value, resp.StatusCode, tt.status)
+		}
+	}
+
+	resp = do("POST", "/sessions/"+created.File+"/prompt", `{"prompt":"hi"}`)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("prompt content type = %q", ct)
+	}
+	var types []string
+	var message RunEvent
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var ev RunEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", data, err)
+		}
+		types = append(types, ev.Type)
+		if ev.Type == RunMessage {
+			message = ev
+		}
+	}
+	resp.Body.Close()
+	if got := strings.Join(types, " "); got != "start usage message finished" {
+		t.Errorf("event types = %s", got)
+	}
+	if message.Content != "hello" {
+		t.Errorf("message event = %+v", message)
+	}
+
+	resp = do("GET", "/sessions/"+created.File, "")
+	var shown struct {
+		Messages []transcriptMessage `json:"messages"`
+	}
+	json.NewDecoder(resp.Body).Decode(&shown)
+	resp.Body.Close()
+	if n := len(shown.Messages); n != 3 || shown.Messages[2].Content != "hello" {
+		t.Errorf("session messages = %+v", shown.Messages)
+	}
+
+	resp = do("POST", "/sessions/"+created.File+"/cancel", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("cancel without a running turn = %d, want 404", resp.StatusCode)
+	}
+
+	id := "20240101-120000-abcdef12"
+	os.WriteFile(filepath.Join(config.SaaDir, "session", id+".stdout.log"), []byte("full output"), 0644)
+	resp = do("GET", "/logs/stdout/"+id, "")
+	var log strings.Builder
+	bufio.NewReader(resp.Body).WriteTo(&log)
+	resp.Body.Close()
+	if log.String() != "full output" {
+		t.Errorf("log = %q", log.String())
+	}
+	resp = do("GET", "/logs/stdout/..%2Fconfig", "")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("invalid log id status = %d, want 400", resp.StatusCode)
+	}
+}
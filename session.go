@@ -2,15 +2,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/sashabaranov/go-openai"
 )
 
 type Session struct {
@@ -18,7 +19,7 @@ type Session struct {
 	SessionDir     string
 	CurrentPtrFile string
 	LogFile        string
-	Messages       []openai.ChatCompletionMessage
+	Messages       []Message
 }
 
 func NewSession(config *Config) *Session {
@@ -56,6 +57,17 @@ func (s *Session) Load() error {
 		return err
 	}
 
+	// A pinned session is used without touching the current pointer, so
+	// several processes can work in separate sessions.
+	if ref := s.Config.Settings.Session; ref != "" {
+		filename, err := s.Resolve(ref)
+		if err != nil {
+			return err
+		}
+		s.LogFile = filepath.Join(s.SessionDir, filename)
+		return s.loadMessages()
+	}
+
 	data, err := os.ReadFile(s.CurrentPtrFile)
 	if err == nil {
 		var ptr struct {
@@ -72,33 +84,41 @@ func (s *Session) Load() error {
 	return s.NewSession()
 }
 
+// NewSession starts a new session file and makes it current.
 func (s *Session) NewSession() error {
-	if err := s.initSessionDir(); err != nil {
+	if err := s.CreateSession(); err != nil {
 		return err
 	}
+	return s.writePointer(filepath.Base(s.LogFile))
+}
 
-	timestamp := time.Now().Format("20060102-150405")
-	u := uuid.New().String()[:8]
-	filename := fmt.Sprintf("%s_%s.jsonl", timestamp, u)
-	s.LogFile = filepath.Join(s.SessionDir, filename)
-
-	ptrData, _ := json.Marshal(map[string]string{"log_file": filename})
-	os.WriteFile(s.CurrentPtrFile, ptrData, 0644)
+// CreateSession starts a new session file without touching the current
+// pointer.
+func (s *Session) CreateSession() error {
+	if err := s.initSessionDir(); err != nil {
+		return err
+	}
+	s.LogFile = filepath.Join(s.SessionDir, newSessionFilename())
 
 	systemPrompt, err := s.Config.ResolveSystemPrompt()
 	if err != nil {
 		return err
 	}
 
-	s.Messages = []openai.ChatCompletionMessage{
+	s.Messages = []Message{
 		{
-			Role:    openai.ChatMessageRoleSystem,
+			Role:    RoleSystem,
 			Content: systemPrompt,
 		},
 	}
 	return s.Save()
 }
 
+// newID returns a short random identifier for file names.
+func newID() string {
+	return uuid.New().String()[:8]
+}
+
 func (s *Session) Clear() error {
 	if err := os.RemoveAll(s.SessionDir); err != nil {
 		return err
@@ -112,11 +132,15 @@ func (s *Session) Switch(filename string) error {
 		return fmt.Errorf("session file not found: %s", filename)
 	}
 
+	return s.writePointer(filename)
+}
+
+func (s *Session) writePointer(filename string) error {
 	ptrData, err := json.Marshal(map[string]string{"log_file": filename})
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(s.CurrentPtrFile, ptrData, 0644)
+	return writeFileAtomic(s.CurrentPtrFile, ptrData, 0644)
 }
 
 func (s *Session) List() ([]string, error) {
@@ -142,58 +166,147 @@ func (s *Session) List() ([]string, error) {
 	return sessionFiles, nil
 }
 
+// loadMessages reads the messages of the current session file. Lines that
+// cannot be read are skipped with a warning pointing to `saa session check`.
 func (s *Session) loadMessages() error {
-	file, err := os.Open(s.LogFile)
+	s.Messages = []Message{}
+	malformed := 0
+	err := scanSessionFile(s.LogFile, func(typ string, line []byte) error {
+		switch typ {
+		case "":
+			msg, err := decodeMessage(line)
+			if err != nil {
+				malformed++
+				return nil
+			}
+			s.Messages = append(s.Messages, msg)
+		case malformedLine:
+			malformed++
+		case EventCompaction:
+			if ev, err := decodeEvent[CompactionEvent](line); err == nil {
+				s.applyCompaction(ev)
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	s.Messages = []openai.ChatCompletionMessage{}
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		var msg openai.ChatCompletionMessage
-		if err := json.Unmarshal(scanner.Bytes(), &msg); err == nil {
-			s.Messages = append(s.Messages, msg)
-		}
+	if malformed > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: skipped %d malformed lines of %s; run `saa session check` for details.\n",
+			malformed, filepath.Base(s.LogFile))
 	}
-	return scanner.Err()
+	return nil
 }
 
-func (s *Session) Save() error {
-	file, err := os.Create(s.LogFile)
+// malformedLine is the type scanSessionFile passes for lines that are not
+// JSON objects, such as a line cut short by a crash.
+const malformedLine = "!malformed"
+
+// scanSessionFile calls fn for every line of a session file with the event
+// type of the line, which is empty for messages and malformedLine for lines
+// that are not JSON objects.
+func scanSessionFile(path string, fn func(typ string, line []byte) error) error {
+	return eachLine(path, func(line []byte) error {
+		var probe struct {
+			Type string `json:"saa"`
+		}
+		if err := json.Unmarshal(line, &probe); err != nil {
+			return fn(malformedLine, line)
+		}
+		return fn(probe.Type, line)
+	})
+}
+
+// eachLine calls fn for every non-empty line of a file, without its trailing
+// newline. Unlike bufio.Scanner, it has no limit on the length of a line.
+func eachLine(path string, fn func(line []byte) error) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	for _, msg := range s.Messages {
-		data, err := json.Marshal(msg)
-		if err != nil {
-			return err
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if line = bytes.TrimRight(line, "\r\n"); len(line) > 0 {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
 		}
-		if _, err := file.Write(data); err != nil {
+		if err != nil {
 			return err
 		}
-		if _, err := file.Write([]byte("\n")); err != nil {
+	}
+}
+
+func decodeMessage(line []byte) (Message, error) {
+	var msg Message
+	err := json.Unmarshal(line, &msg)
+	return msg, err
+}
+
+func decodeEvent[T any](line []byte) (T, error) {
+	var ev T
+	err := json.Unmarshal(line, &ev)
+	return ev, err
+}
+
+func (s *Session) Save() error {
+	var buf bytes.Buffer
+	for _, msg := range s.Messages {
+		data, err := json.Marshal(msg)
+		if err != nil {
 			return err
 		}
+		buf.Write(data)
+		buf.WriteByte('\n')
 	}
-	return nil
+	return writeFileAtomic(s.LogFile, buf.Bytes(), 0644)
 }
 
-func (s *Session) AddMessage(msg openai.ChatCompletionMessage) error {
+func (s *Session) AddMessage(msg Message) error {
 	s.Messages = append(s.Messages, msg)
-	file, err := os.OpenFile(s.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	return appendJSONLine(s.LogFile, msg)
+}
+
+// AddEvent records a non-message line in the session file. Events carry a
+// "saa" field with their type, which messages never have, and do not
+// change Messages.
+func (s *Session) AddEvent(event any) error {
+	return appendJSONLine(s.LogFile, event)
+}
+
+// AddUsage records the usage of a model request in the usage sidecar of
+// the session.
+func (s *Session) AddUsage(ev UsageEvent) error {
+	return appendJSONLine(usagePath(s.LogFile), ev)
+}
+
+// appendJSONLine appends v to the JSONL file path as one line.
+func appendJSONLine(path string, v any) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	data, err := json.Marshal(msg)
+	data, err := json.Marshal(v)
 	if err != nil {
 		return err
 	}
+	// A line cut short by a crash must not swallow the new one.
+	if fi, err := file.Stat(); err == nil && fi.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, fi.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
 	if _, err := file.Write(data); err != nil {
 		return err
 	}
@@ -203,6 +316,39 @@ func (s *Session) AddMessage(msg openai.ChatCompletionMessage) error {
 	return nil
 }
 
+// Compact records a compaction marker and replaces everything between the
+// system prompt and the last keep messages with summary. The original
+// messages stay in the session file.
+func (s *Session) Compact(summary string, keep int) error {
+	ev := CompactionEvent{
+		Type:    EventCompaction,
+		Time:    time.Now(),
+		Summary: summary,
+		Keep:    keep,
+	}
+	if err := s.AddEvent(ev); err != nil {
+		return err
+	}
+	s.applyCompaction(ev)
+	return nil
+}
+
+func (s *Session) applyCompaction(ev CompactionEvent) {
+	var head []Message
+	rest := s.Messages
+	if len(rest) > 0 && rest[0].Role == RoleSystem {
+		head, rest = rest[:1], rest[1:]
+	}
+
+	keep := min(max(ev.Keep, 0), len(rest))
+	messages := append([]Message{}, head...)
+	messages = append(messages, Message{
+		Role:    RoleUser,
+		Content: SummaryPrefix + ev.Summary,
+	})
+	s.Messages = append(messages, rest[len(rest)-keep:]...)
+}
+
 const SystemPrompt = `You are SAA (Single Action Agent).
 You perform tasks autonomously by utilizing Bash commands to fulfill user instructions.
 
@@ -5,8 +5,6 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
-
-	"github.com/sashabaranov/go-openai"
 )
 
 func TestSessionLifecycle(t *testing.T) {
@@ -46,8 +44,8 @@ func TestSessionLifecycle(t *testing.T) {
 	}
 
 	// 2. Add Message
-	msg := openai.ChatCompletionMessage{
-		Role:    openai.ChatMessageRoleUser,
+	msg := Message{
+		Role:    RoleUser,
 		Content: "Hello",
 	}
 	if err := session.AddMessage(msg); err != nil {
@@ -93,6 +91,14 @@ func TestSessionLifecycle(t *testing.T) {
 		t.Error("session file did not change after NewSession")
 	}
 
+	// A created session does not become current.
+	if err := session.CreateSession(); err != nil {
+		t.Fatalf("CreateSession failed: %v", err)
+	}
+	if current, _ := session.GetCurrentLogFile(); current != newLogFile {
+		t.Errorf("expected CreateSession to keep current %s, got %s", newLogFile, current)
+	}
+
 	// 5. Switch
 	if err := session.Switch(logFile); err != nil {
 		t.Fatalf("Switch failed: %v", err)
@@ -112,17 +118,17 @@ func TestSessionLifecycle(t *testing.T) {
 	}
 }
 
-func readMessages(path string) ([]openai.ChatCompletionMessage, error) {
+func readMessages(path string) ([]Message, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	var msgs []openai.ChatCompletionMessage
+	var msgs []Message
 	decoder := json.NewDecoder(file)
 	for decoder.More() {
-		var msg openai.ChatCompletionMessage
+		var msg Message
 		if err := decoder.Decode(&msg); err != nil {
 			return nil, err
 		}
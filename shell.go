@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// interruptGrace is how long a timed out command gets to exit after SIGINT
+// before the whole shell is killed and restarted.
+const interruptGrace = 2 * time.Second
+
+// PersistentShell runs commands in one long-lived bash process so that the
+// working directory, variables and functions survive between tool calls.
+type PersistentShell struct {
+	WorkDir string
+	Runner  Runner
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	stdout   chan shellChunk
+	stderr   chan shellChunk
+	exited   chan struct{}
+	sentinel string
+}
+
+// shellChunk is the output of one command, up to the sentinel line.
+// eof is set when the stream ended without a sentinel (the shell died).
+type shellChunk struct {
+	text string
+	tail string
+	eof  bool
+}
+
+// NewPersistentShell creates a shell that is started through runner on
+// first use. A nil runner runs bash directly.
+func NewPersistentShell(runner Runner, workDir string) *PersistentShell {
+	if runner == nil {
+		runner = PlainRunner{}
+	}
+	return &PersistentShell{WorkDir: workDir, Runner: runner}
+}
+
+func (s *PersistentShell) start() error {
+	absWorkDir, err := filepath.Abs(s.WorkDir)
+	if err != nil {
+		return err
+	}
+
+	args, err := s.Runner.Wrap([]string{"/bin/bash", "--noprofile", "--norc"}, absWorkDir)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = absWorkDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	s.cmd = cmd
+	s.stdin = stdin
+	s.sentinel = "__SAA_" + strings.ReplaceAll(uuid.New().String(), "-", "") + "__"
+	s.stdout = make(chan shellChunk)
+	s.stderr = make(chan shellChunk)
+	s.exited = make(chan struct{})
+
+	var readers sync.WaitGroup
+	readers.Add(2)
+	go func() {
+		defer readers.Done()
+		s.readChunks(stdout, s.stdout)
+	}()
+	go func() {
+		defer readers.Done()
+		s.readChunks(stderr, s.stderr)
+	}()
+	go func() {
+		readers.Wait()
+		cmd.Wait()
+		close(s.exited)
+	}()
+
+	// A trapped SIGINT is reset to the default in child processes, so an
+	// interrupt sent to the process group stops the foreground job while the
+	// shell itself keeps running.
+	_, err = io.WriteString(stdin, "trap : INT\nset -o pipefail\n")
+	return err
+}
+
+func (s *PersistentShell) readChunks(r io.Reader, out chan<- shellChunk) {
+	defer close(out)
+	br := bufio.NewReader(r)
+	var buf strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if rest, ok := strings.CutPrefix(line, s.sentinel); ok {
+			// The sentinel is printed after an extra newline; drop it again.
+			text := strings.TrimSuffix(buf.String(), "\n")
+			out <- shellChunk{text: text, tail: strings.TrimSpace(rest)}
+			buf.Reset()
+			continue
+		}
+		buf.WriteString(line)
+		if err != nil {
+			out <- shellChunk{text: buf.String(), eof: true}
+			return
+		}
+	}
+}
+
+func (s *PersistentShell) running() bool {
+	if s.cmd == nil {
+		return false
+	}
+	select {
+	case <-s.exited:
+		return false
+	default:
+		return true
+	}
+}
+
+// Run executes command in the shell. A command that exits the shell (for
+// example `exit` or a failure under `set -e`) returns its output, and the
+// next call starts a fresh shell. Cancelling ctx interrupts the command like
+// a timeout and returns ctx.Err().
+func (s *PersistentShell) Run(ctx context.Context, command string, timeout time.Duration) (BashResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running() {
+		if err := s.start(); err != nil {
+			return BashResult{}, err
+		}
+	}
+
+	line := fmt.Sprintf("eval %s </dev/null; printf '\\n%s %%d\\n' \"$?\"; printf '\\n%s\\n' >&2\n",
+		ansiCQuote(command), s.sentinel, s.sentinel)
+	if _, err := io.WriteString(s.stdin, line); err != nil {
+		s.kill()
+		return BashResult{}, err
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	var stdout, stderr *shellChunk
+	interrupted := false
+	done := ctx.Done()
+	interrupt := func() {
+		interrupted = true
+		syscall.Kill(-s.cmd.Process.Pid, syscall.SIGINT)
+		timer = time.After(interruptGrace)
+		done = nil
+	}
+	for stdout == nil || stderr == nil {
+		select {
+		case c := <-s.stdout:
+			stdout = &c
+		case c := <-s.stderr:
+			stderr = &c
+		case <-done:
+			interrupt()
+		case <-timer:
+			if interrupted {
+				// The job ignored the interrupt; give up on this shell.
+				s.kill()
+				stdout, stderr = &shellChunk{}, &shellChunk{}
+				break
+			}
+			interrupt()
+		}
+	}
+
+	if ctx.Err() != nil {
+		return BashResult{}, ctx.Err()
+	}
+	if interrupted {
+		return timeoutResult(timeout), nil
+	}
+
+	if stdout.eof || stderr.eof {
+		<-s.exited
+		return BashResult{
+			Stdout:   stdout.text,
+			Stderr:   stderr.text + "\n(The shell exited; its state has been reset.)",
+			ExitCode: s.cmd.ProcessState.ExitCode(),
+		}, nil
+	}
+
+	exitCode, err := strconv.Atoi(stdout.tail)
+	if err != nil {
+		return BashResult{}, fmt.Errorf("invalid exit code from shell: %q", stdout.tail)
+	}
+
+	return BashResult{
+		Stdout:   stdout.text,
+		Stderr:   stderr.text,
+		ExitCode: exitCode,
+	}, nil
+}
+
+func (s *PersistentShell) kill() {
+	syscall.Kill(-s.cmd.Process.Pid, syscall.SIGKILL)
+	s.drain()
+}
+
+// drain discards remaining output until the shell has exited.
+func (s *PersistentShell) drain() {
+	stdout, stderr := s.stdout, s.stderr
+	for stdout != nil || stderr != nil {
+		select {
+		case _, ok := <-stdout:
+			if !ok {
+				stdout = nil
+			}
+		case _, ok := <-stderr:
+			if !ok {
+				stderr = nil
+			}
+		}
+	}
+	<-s.exited
+}
+
+// Close terminates the shell and any job still running in it.
+func (s *PersistentShell) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running() {
+		return nil
+	}
+	s.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		s.drain()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(interruptGrace):
+		s.kill()
+	}
+	return nil
+}
+
+func timeoutResult(timeout time.Duration) BashResult {
+	return BashResult{
+		Stderr:   fmt.Sprintf("Error: Command timed out after %v.", timeout),
+		ExitCode: -1,
+	}
+}
+
+// ansiCQuote quotes s as a bash $'...' string so it fits on a single line.
+func ansiCQuote(s string) string {
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\':
+			b.WriteString(`\\`)
+		case c == '\'':
+			b.WriteString(`\'`)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString("'")
+	return b.String()
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistentShell(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	shell := NewPersistentShell(nil, tmpDir)
+	defer shell.Close()
+
+	run := func(command string, timeout time.Duration) BashResult {
+		t.Helper()
+		res, err := shell.Run(context.Background(), command, timeout)
+		if err != nil {
+			t.Fatalf("Run(%q) failed: %v", command, err)
+		}
+		return res
+	}
+
+	// State survives between calls
+	run("mkdir sub && cd sub && export FOO=bar && greet() { echo \"hi $1\"; }", 0)
+	res := run("pwd; echo $FOO; greet there", 0)
+	expected := tmpDir + "/sub\nbar\nhi there\n"
+	if res.Stdout != expected {
+		t.Errorf("expected stdout %q, got %q", expected, res.Stdout)
+	}
+
+	// Output without a trailing newline, stderr and exit codes
+	res = run("printf abc; echo 'oops' >&2; false", 0)
+	if res.Stdout != "abc" {
+		t.Errorf("expected stdout %q, got %q", "abc", res.Stdout)
+	}
+	if res.Stderr != "oops\n" {
+		t.Errorf("expected stderr %q, got %q", "oops\n", res.Stderr)
+	}
+	if res.ExitCode != 1 {
+		t.Errorf("expected exit code 1, got %d", res.ExitCode)
+	}
+
+	// Quotes and multi-line commands
+	res = run("cat <<'EOF'\nit's a \\ test\nEOF", 0)
+	if res.Stdout != "it's a \\ test\n" {
+		t.Errorf("unexpected heredoc output %q", res.Stdout)
+	}
+
+	// Commands do not read the control pipe
+	res = run("cat; echo after", 0)
+	if res.Stdout != "after\n" {
+		t.Errorf("expected stdin to be empty, got %q", res.Stdout)
+	}
+
+	// A timeout kills the job but keeps the shell
+	res = run("sleep 5", 100*time.Millisecond)
+	if res.ExitCode != -1 || !strings.Contains(res.Stderr, "timed out") {
+		t.Errorf("expected timeout result, got %+v", res)
+	}
+	res = run("echo $FOO", 0)
+	if res.Stdout != "bar\n" {
+		t.Errorf("expected state to survive timeout, got %q", res.Stdout)
+	}
+
+	// Exiting the shell resets its state
+	res = run("exit 3", 0)
+	if res.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %d", res.ExitCode)
+	}
+	res = run("echo ${FOO:-unset}; pwd", 0)
+	if res.Stdout != "unset\n"+tmpDir+"\n" {
+		t.Errorf("expected a fresh shell, got %q", res.Stdout)
+	}
+}
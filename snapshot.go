@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Snapshots is a shadow git repository under .saa that records the project
+// tree before and after each tool call. It has its own git directory, so it
+// works whether or not the project is a git repository, and it honors the
+// project's .gitignore files. Snapshots are trees; identical files are
+// stored once.
+type Snapshots struct {
+	gitDir   string
+	workTree string
+	index    string
+}
+
+// NewSnapshots opens the shadow repository of the project, creating it if
+// needed. index names the git index to use, so that sessions running in
+// parallel do not share one.
+func NewSnapshots(config *Config, index string) (*Snapshots, error) {
+	if _, err := exec.LookPath("git"); err != nil {
+		return nil, fmt.Errorf("snapshots need git: %w", err)
+	}
+	s := &Snapshots{
+		gitDir:   filepath.Join(config.SaaDir, "snapshots"),
+		workTree: config.ProjectRoot,
+		index:    snapshotIndexPath(config, index),
+	}
+
+	if _, err := os.Stat(filepath.Join(s.gitDir, "HEAD")); err == nil {
+		return s, nil
+	}
+	if _, err := s.git(nil, "init", "--quiet"); err != nil {
+		return nil, err
+	}
+	if _, err := s.git(nil, "config", "gc.auto", "0"); err != nil {
+		return nil, err
+	}
+	exclude := filepath.Join(s.gitDir, "info", "exclude")
+	if err := os.MkdirAll(filepath.Dir(exclude), 0755); err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(s.workTree, config.SaaDir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = ".saa"
+	}
+	return s, os.WriteFile(exclude, []byte("/"+filepath.ToSlash(rel)+"/\n"), 0644)
+}
+
+// snapshotIndexPath returns the git index of the snapshots named index.
+func snapshotIndexPath(config *Config, index string) string {
+	return filepath.Join(config.SaaDir, "snapshots", "index-"+index)
+}
+
+// git runs git on the shadow repository with the project as work tree.
+func (s *Snapshots) git(stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-c", "core.autocrlf=false", "-c", "core.quotepath=false"}, args...)...)
+	cmd.Dir = s.workTree
+	cmd.Stdin = stdin
+	cmd.Env = append(os.Environ(),
+		"GIT_DIR="+s.gitDir,
+		"GIT_WORK_TREE="+s.workTree,
+		"GIT_INDEX_FILE="+s.index,
+		"GIT_AUTHOR_NAME=saa", "GIT_AUTHOR_EMAIL=saa@localhost",
+		"GIT_COMMITTER_NAME=saa", "GIT_COMMITTER_EMAIL=saa@localhost",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// Take records the current project tree and returns its id.
+func (s *Snapshots) Take(message string) (string, error) {
+	if _, err := s.git(nil, "add", "--all", "."); err != nil {
+		return "", err
+	}
+	tree, err := s.git(nil, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	tree = strings.TrimSpace(tree)
+
+	// A commit on a ref keeps the tree from being garbage collected.
+	args := []string{"commit-tree", tree, "-m", message}
+	if parent, err := s.git(nil, "rev-parse", "--verify", "--quiet", "refs/heads/snapshots"); err == nil {
+		args = append(args, "-p", strings.TrimSpace(parent))
+	}
+	commit, err := s.git(nil, args...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.git(nil, "update-ref", "refs/heads/snapshots", strings.TrimSpace(commit)); err != nil {
+		return "", err
+	}
+	return tree, nil
+}
+
+// Diff returns the changes between two snapshots as a patch.
+func (s *Snapshots) Diff(from, to string, stat bool) (string, error) {
+	args := []string{"diff", "--binary", from, to}
+	if stat {
+		args = []string{"diff", "--stat", from, to}
+	}
+	return s.git(nil, args...)
+}
+
+// Revert undoes the changes between two snapshots in the project. It fails
+// without changing anything if later changes conflict with it.
+func (s *Snapshots) Revert(from, to string) error {
+	patch, err := s.Diff(from, to, false)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(patch) == "" {
+		return nil
+	}
+	if _, err := s.git(strings.NewReader(patch), "apply", "--reverse", "--whitespace=nowarn", "-"); err != nil {
+		return fmt.Errorf("cannot undo, the files changed since: %w", err)
+	}
+	return nil
+}
+
+// snapshot records the project tree when snapshots are on and returns its
+// id. After a failure, snapshots stay off for the life of the agent.
+func (a *Agent) snapshot() string {
+	if !a.Config.Settings.Snapshots || a.snapshotsFailed {
+		return ""
+	}
+	if a.snapshots == nil {
+		s, err := NewSnapshots(a.Config, sessionID(filepath.Base(a.Session.LogFile)))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Snapshots disabled: %v\n", err)
+			a.snapshotsFailed = true
+			return ""
+		}
+		a.snapshots = s
+	}
+	tree, err := a.snapshots.Take(filepath.Base(a.Session.LogFile))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Snapshots disabled: %v\n", err)
+		a.snapshotsFailed = true
+		return ""
+	}
+	return tree
+}
+
+// recordSnapshot takes the snapshot after a tool call and records both in
+// the session.
+func (a *Agent) recordSnapshot(toolCallID, command, before string) error {
+	if before == "" {
+		return nil
+	}
+	after := a.snapshot()
+	if after == "" {
+		return nil
+	}
+	return a.Session.AddEvent(SnapshotEvent{
+		Type:       EventSnapshot,
+		Time:       time.Now(),
+		ToolCallID: toolCallID,
+		Command:    command,
+		Before:     before,
+		After:      after,
+	})
+}
+
+// snapshotTurn is the snapshots of the tool calls of one assistant message.
+type snapshotTurn struct {
+	Turn   int
+	Calls  []SnapshotEvent
+	Undone bool
+}
+
+// readSnapshotTurns returns the tool-call turns of a session file that have
+// snapshots. Turns number the assistant messages with tool calls from 1.
+func readSnapshotTurns(path string) ([]snapshotTurn, error) {
+	turnOf := map[string]int{}
+	turn := 0
+	var turns []snapshotTurn
+	err := scanSessionFile(path, func(typ string, line []byte) error {
+		switch typ {
+		case "":
+			msg, err := decodeMessage(line)
+			if err != nil || msg.Role != RoleAssistant || len(msg.ToolCalls) == 0 {
+				return nil
+			}
+			turn++
+			for _, tc := range msg.ToolCalls {
+				turnOf[tc.ID] = turn
+			}
+		case EventSnapshot:
+			ev, err := decodeEvent[SnapshotEvent](line)
+			if err != nil {
+				return nil
+			}
+			t := turnOf[ev.ToolCallID]
+			if len(turns) == 0 || turns[len(turns)-1].Turn != t {
+				turns = append(turns, snapshotTurn{Turn: t})
+			}
+			turns[len(turns)-1].Calls = append(turns[len(turns)-1].Calls, ev)
+		case EventUndo:
+			ev, err := decodeEvent[UndoEvent](line)
+			if err != nil {
+				return nil
+			}
+			for i := range turns {
+				if turns[i].Turn == ev.Turn {
+					turns[i].Undone = true
+				}
+			}
+		}
+		return nil
+	})
+	return turns, err
+}
+
+// findSnapshotTurn returns turn n, or if n is 0 the last turn with
+// snapshots that was not undone.
+func findSnapshotTurn(turns []snapshotTurn, n int) (snapshotTurn, error) {
+	if len(turns) == 0 {
+		return snapshotTurn{}, fmt.Errorf("no snapshots in this session (set \"snapshots\": true to record them)")
+	}
+	for i := len(turns) - 1; i >= 0; i-- {
+		t := turns[i]
+		if n == 0 && !t.Undone || t.Turn == n {
+			return t, nil
+		}
+	}
+	if n == 0 {
+		return snapshotTurn{}, fmt.Errorf("every turn with snapshots was undone")
+	}
+	return snapshotTurn{}, fmt.Errorf("no snapshots for turn %d", n)
+}
+
+// PrintDiff writes the changes made by each tool call of a turn.
+func (s *Snapshots) PrintDiff(w io.Writer, turn snapshotTurn, stat bool) error {
+	for _, call := range turn.Calls {
+		patch, err := s.Diff(call.Before, call.After, stat)
+		if err != nil {
+			return err
+		}
+		if patch == "" {
+			patch = "(no changes)\n"
+		}
+		fmt.Fprintf(w, "# turn %d: %s\n%s", turn.Turn, call.Command, patch)
+	}
+	return nil
+}
+
+// Undo reverts the changes made by all tool calls of a turn.
+func (s *Snapshots) Undo(turn snapshotTurn) error {
+	calls := turn.Calls
+	return s.Revert(calls[0].Before, calls[len(calls)-1].After)
+}
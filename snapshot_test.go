@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshotUndo(t *testing.T) {
+	if _, err := os.Stat("/usr/bin/git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		if requests == 1 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","tool_calls":[
+				{"id":"1","type":"function","function":{"name":"bash","arguments":"{\"command\":\"sed -i s/hello/wrecked/ a.txt\"}"}},
+				{"id":"2","type":"function","function":{"name":"bash","arguments":"{\"command\":\"echo new > b.txt && echo x > ignored.log\"}"}}
+			]}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"done"}}]}`)
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("hello\n"), 0644)
+	os.WriteFile(filepath.Join(root, ".gitignore"), []byte("*.log\n"), 0644)
+
+	config := &Config{
+		ProjectRoot: root,
+		SaaDir:      filepath.Join(root, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test", Snapshots: true},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+	if err := agent.Run(context.Background(), "edit"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	turns, err := readSnapshotTurns(session.LogFile)
+	if err != nil || len(turns) != 1 || len(turns[0].Calls) != 2 {
+		t.Fatalf("readSnapshotTurns = %+v, %v", turns, err)
+	}
+	turn, err := findSnapshotTurn(turns, 0)
+	if err != nil || turn.Turn != 1 {
+		t.Fatalf("findSnapshotTurn = %+v, %v", turn, err)
+	}
+
+	var diff strings.Builder
+	if err := agent.snapshots.PrintDiff(&diff, turn, false); err != nil {
+		t.Fatalf("PrintDiff failed: %v", err)
+	}
+	for _, want := range []string{"-hello", "+wrecked", "b.txt"} {
+		if !strings.Contains(diff.String(), want) {
+			t.Errorf("diff does not contain %q:\n%s", want, diff.String())
+		}
+	}
+	if strings.Contains(diff.String(), "b/ignored.log") {
+		t.Errorf("diff contains an ignored file:\n%s", diff.String())
+	}
+
+	if err := agent.snapshots.Undo(turn); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(root, "a.txt")); string(data) != "hello\n" {
+		t.Errorf("a.txt = %q after undo", data)
+	}
+	if _, err := os.Stat(filepath.Join(root, "b.txt")); !os.IsNotExist(err) {
+		t.Errorf("b.txt still exists after undo")
+	}
+	if _, err := os.Stat(filepath.Join(root, "ignored.log")); err != nil {
+		t.Errorf("undo touched an ignored file: %v", err)
+	}
+
+	session.AddEvent(UndoEvent{Type: EventUndo, Turn: 1})
+	turns, _ = readSnapshotTurns(session.LogFile)
+	if _, err := findSnapshotTurn(turns, 0); err == nil {
+		t.Errorf("findSnapshotTurn returned an undone turn")
+	}
+}
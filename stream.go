@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamAccumulator reassembles the deltas of an OpenAI stream into a
+// complete assistant message.
+type streamAccumulator struct {
+	role      string
+	content   strings.Builder
+	reasoning strings.Builder
+	toolCalls []openai.ToolCall
+}
+
+func (s *streamAccumulator) add(delta openai.ChatCompletionStreamChoiceDelta) {
+	if delta.Role != "" {
+		s.role = delta.Role
+	}
+	s.content.WriteString(delta.Content)
+	s.reasoning.WriteString(delta.ReasoningContent)
+
+	for _, tc := range delta.ToolCalls {
+		idx := len(s.toolCalls) - 1
+		switch {
+		case tc.Index != nil:
+			idx = *tc.Index
+		case tc.ID != "" && (idx < 0 || s.toolCalls[idx].ID != tc.ID):
+			// Servers that omit the index start a new call with a fresh ID.
+			idx = len(s.toolCalls)
+		}
+		if idx < 0 {
+			idx = 0
+		}
+		for len(s.toolCalls) <= idx {
+			s.toolCalls = append(s.toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+
+		call := &s.toolCalls[idx]
+		if tc.ID != "" {
+			call.ID = tc.ID
+		}
+		if tc.Type != "" {
+			call.Type = tc.Type
+		}
+		if tc.Function.Name != "" {
+			call.Function.Name = tc.Function.Name
+		}
+		call.Function.Arguments += tc.Function.Arguments
+	}
+}
+
+func (s *streamAccumulator) message() openai.ChatCompletionMessage {
+	role := s.role
+	if role == "" {
+		role = openai.ChatMessageRoleAssistant
+	}
+	return openai.ChatCompletionMessage{
+		Role:             role,
+		Content:          s.content.String(),
+		ReasoningContent: s.reasoning.String(),
+		ToolCalls:        s.toolCalls,
+	}
+}
+
+// streamPrinter writes reasoning and message deltas as they arrive,
+// emitting the section headers once per section.
+type streamPrinter struct {
+	w             io.Writer
+	showReasoning bool
+	showHeader    bool
+	section       string
+	lastNewline   bool
+
+	// emit, if set, receives the deltas as events instead of w.
+	emit func(RunEvent)
+}
+
+func (p *streamPrinter) write(section, text string) {
+	if text == "" {
+		return
+	}
+	if p.emit != nil {
+		p.emit(RunEvent{Type: section + "_delta", Content: text})
+		return
+	}
+	if section != p.section {
+		p.finish()
+		switch section {
+		case "reasoning":
+			fmt.Fprint(p.w, "[REASONING]\n")
+		case "message":
+			if p.showHeader {
+				fmt.Fprint(p.w, "[MESSAGE]\n")
+			}
+		}
+		p.section = section
+	}
+	fmt.Fprint(p.w, text)
+	p.lastNewline = strings.HasSuffix(text, "\n")
+}
+
+func (p *streamPrinter) reasoning(text string) {
+	if p.showReasoning {
+		p.write("reasoning", text)
+	}
+}
+
+func (p *streamPrinter) content(text string) {
+	p.write("message", text)
+}
+
+// finish terminates the current section with a newline if needed.
+func (p *streamPrinter) finish() {
+	if p.emit != nil {
+		return
+	}
+	if p.section != "" && !p.lastNewline {
+		fmt.Fprint(p.w, "\n")
+	}
+	p.section = ""
+	p.lastNewline = false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestStreamAccumulator(t *testing.T) {
+	idx0, idx1 := 0, 1
+	deltas := []openai.ChatCompletionStreamChoiceDelta{
+		{Role: openai.ChatMessageRoleAssistant, ReasoningContent: "think"},
+		{ReasoningContent: "ing"},
+		{Content: "Hello"},
+		{Content: ", world"},
+		{ToolCalls: []openai.ToolCall{{Index: &idx0, ID: "call_1", Function: openai.FunctionCall{Name: "bash"}}}},
+		{ToolCalls: []openai.ToolCall{{Index: &idx0, Function: openai.FunctionCall{Arguments: `{"comm`}}}},
+		{ToolCalls: []openai.ToolCall{{Index: &idx1, ID: "call_2", Function: openai.FunctionCall{Name: "bash", Arguments: `{"command"`}}}},
+		{ToolCalls: []openai.ToolCall{{Index: &idx0, Function: openai.FunctionCall{Arguments: `and":"ls"}`}}}},
+		{ToolCalls: []openai.ToolCall{{Index: &idx1, Function: openai.FunctionCall{Arguments: `:"pwd"}`}}}},
+	}
+
+	var acc streamAccumulator
+	for _, d := range deltas {
+		acc.add(d)
+	}
+	msg := acc.message()
+
+	if msg.Role != openai.ChatMessageRoleAssistant {
+		t.Errorf("expected assistant role, got %q", msg.Role)
+	}
+	if msg.Content != "Hello, world" {
+		t.Errorf("unexpected content %q", msg.Content)
+	}
+	if msg.ReasoningContent != "thinking" {
+		t.Errorf("unexpected reasoning %q", msg.ReasoningContent)
+	}
+	if len(msg.ToolCalls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %d", len(msg.ToolCalls))
+	}
+	if msg.ToolCalls[0].ID != "call_1" || msg.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected first tool call %+v", msg.ToolCalls[0])
+	}
+	if msg.ToolCalls[1].ID != "call_2" || msg.ToolCalls[1].Function.Arguments != `{"command":"pwd"}` {
+		t.Errorf("unexpected second tool call %+v", msg.ToolCalls[1])
+	}
+}
+
+func TestStreamAccumulatorWithoutIndex(t *testing.T) {
+	deltas := []openai.ChatCompletionStreamChoiceDelta{
+		{ToolCalls: []openai.ToolCall{{ID: "a", Function: openai.FunctionCall{Name: "bash", Arguments: `{"command":`}}}},
+		{ToolCalls: []openai.ToolCall{{Function: openai.FunctionCall{Arguments: `"ls"}`}}}},
+		{ToolCalls: []openai.ToolCall{{ID: "b", Function: openai.FunctionCall{Name: "bash", Arguments: `{"command":"pwd"}`}}}},
+	}
+
+	var acc streamAccumulator
+	for _, d := range deltas {
+		acc.add(d)
+	}
+	msg := acc.message()
+
+	if len(msg.ToolCalls) != 2 {
+		t.Fatalf("expected 2 tool calls, got %d", len(msg.ToolCalls))
+	}
+	if msg.ToolCalls[0].Function.Arguments != `{"command":"ls"}` {
+		t.Errorf("unexpected arguments %q", msg.ToolCalls[0].Function.Arguments)
+	}
+}
+
+func TestStreamCompletion(t *testing.T) {
+	chunks := []string{
+		`{"choices":[{"index":0,"delta":{"role":"assistant","reasoning_content":"hmm"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"Done"}}]}`,
+		`{"choices":[{"index":0,"delta":{"content":"."}}]}`,
+		`{"choices":[],"usage":{"prompt_tokens":10,"completion_tokens":3,"total_tokens":13}}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, c := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", c)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	tmpDir, err := os.MkdirTemp("", "saa-test-stream")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	config := &Config{
+		SaaDir:   filepath.Join(tmpDir, ".saa"),
+		Settings: Settings{APIURL: server.URL, Model: "test"},
+	}
+	agent := NewAgent(config, NewSession(config))
+
+	var out bytes.Buffer
+	printer := &streamPrinter{w: &out, showReasoning: true, showHeader: true}
+	resp, err := agent.Provider.Complete(context.Background(), CompletionRequest{Model: "test"}, func(reasoning, content string) {
+		printer.reasoning(reasoning)
+		printer.content(content)
+	})
+	printer.finish()
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+	msg := resp.Message
+
+	if msg.Content != "Done." || msg.ReasoningContent != "hmm" {
+		t.Errorf("unexpected message %+v", msg)
+	}
+	if resp.Usage.TotalTokens != 13 {
+		t.Errorf("expected usage from the last chunk, got %+v", resp.Usage)
+	}
+	expected := "[REASONING]\nhmm\n[MESSAGE]\nDone.\n"
+	if out.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+
+	"golang.org/x/sys/unix"
+)
+
+// isTerminal reports whether f is a character device such as a TTY.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// makeRaw puts the terminal into a raw-ish mode for line editing: no echo,
+// no line buffering and no signals from control keys. Output processing is
+// kept so "\n" still moves to the start of the next line. It returns the
+// previous state for restoreTerminal.
+func makeRaw(fd int) (*unix.Termios, error) {
+	old, err := unix.IoctlGetTermios(fd, ioctlReadTermios)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlWriteTermios, &raw); err != nil {
+		return nil, err
+	}
+	return old, nil
+}
+
+func restoreTerminal(fd int, state *unix.Termios) error {
+	return unix.IoctlSetTermios(fd, ioctlWriteTermios, state)
+}
+
+// terminalWidth returns the number of columns of the terminal, or 80 if it
+// cannot be determined.
+func terminalWidth(fd int) int {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 80
+	}
+	return int(ws.Col)
+}
+
+// withPager runs write with its output going through $PAGER (less by
+// default) when stdout is a terminal, and straight to stdout otherwise.
+func withPager(write func(w io.Writer) error) error {
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -R"
+	}
+	if !isTerminal(os.Stdout) || pager == "cat" {
+		return write(os.Stdout)
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return write(os.Stdout)
+	}
+	if err := cmd.Start(); err != nil {
+		return write(os.Stdout)
+	}
+
+	werr := write(in)
+	in.Close()
+	if err := cmd.Wait(); err != nil {
+		return nil // the pager was quit early
+	}
+	return werr
+}
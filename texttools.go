@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// textToolsPrompt is added to the system prompt with text_tools, for
+// models that do not emit native tool calls.
+const textToolsPrompt = `
+
+Running commands:
+
+To run a bash command, put it in a <bash> tag at the end of your message:
+
+<bash>
+ls -la
+</bash>
+
+Add timeout="SECONDS" to the tag to limit how long the command may run, e.g. <bash timeout="60">.
+Run one command per message and wait for its result, which comes in the next message.
+When the task is complete, answer without a <bash> tag.`
+
+var textToolTagRe = regexp.MustCompile(`(?s)<bash(?:\s+timeout="?(\d+)"?)?\s*>(.*?)</bash>`)
+
+// parseTextToolCalls returns the bash tool calls written as <bash> tags in
+// content. Fenced code blocks are not run: answers often show example
+// commands in them. The calls get new IDs, so they are recorded exactly like
+// native ones.
+func parseTextToolCalls(content string) []ToolCall {
+	matches := textToolTagRe.FindAllStringSubmatch(content, -1)
+
+	var calls []ToolCall
+	for _, m := range matches {
+		var args struct {
+			Command string `json:"command"`
+			Timeout int    `json:"timeout,omitempty"`
+		}
+		args.Command = strings.TrimSpace(m[2])
+		if args.Command == "" {
+			continue
+		}
+		args.Timeout, _ = strconv.Atoi(m[1])
+		data, _ := json.Marshal(args)
+		calls = append(calls, ToolCall{
+			ID:       "text_" + newID(),
+			Type:     ToolTypeFunction,
+			Function: FunctionCall{Name: "bash", Arguments: string(data)},
+		})
+	}
+	return calls
+}
+
+// textToolRequest rewrites a request for a model without native tool calls:
+// the tools are described in the system prompt instead of sent, and tool
+// calls and results in the history become plain text.
+func textToolRequest(req CompletionRequest) CompletionRequest {
+	msgs := make([]Message, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		switch {
+		case m.Role == RoleSystem && len(req.Tools) > 0:
+			m.Content += textToolsPrompt
+		case m.Role == RoleAssistant && len(m.ToolCalls) > 0:
+			// Calls parsed from text are already in the content; native
+			// ones from earlier turns are written out.
+			if len(parseTextToolCalls(m.Content)) == 0 {
+				for _, tc := range m.ToolCalls {
+					m.Content += fmt.Sprintf("\n<bash>\n%s\n</bash>", toolCallCommand(tc))
+				}
+				m.Content = strings.TrimSpace(m.Content)
+			}
+			m.ToolCalls = nil
+		case m.Role == RoleTool:
+			m = Message{Role: RoleUser, Content: fmt.Sprintf("Command result:\n%s", m.Content)}
+		}
+		msgs = append(msgs, m)
+	}
+	req.Messages = msgs
+	req.Tools = nil
+	return req
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseTextToolCalls(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"Let me look.\n<bash>\nls -la\n</bash>", []string{`{"command":"ls -la"}`}},
+		{`<bash timeout="30">make test</bash>`, []string{`{"command":"make test","timeout":30}`}},
+		// Fenced blocks are examples, not commands.
+		{"Run this:\n```bash\necho hi\n```\n", nil},
+		{"```bash\nrm -rf build\n```\n<bash>ls</bash>", []string{`{"command":"ls"}`}},
+		{"```go\nfmt.Println()\n```", nil},
+		{"<bash>  </bash>", nil},
+		{"All done.", nil},
+	}
+	for _, tt := range tests {
+		calls := parseTextToolCalls(tt.content)
+		var got []string
+		for _, tc := range calls {
+			if tc.ID == "" || tc.Function.Name != "bash" {
+				t.Errorf("unexpected call %+v", tc)
+			}
+			got = append(got, tc.Function.Arguments)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("parseTextToolCalls(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestRunTextTools(t *testing.T) {
+	var requests []map[string]json.RawMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]json.RawMessage
+		json.NewDecoder(r.Body).Decode(&req)
+		requests = append(requests, req)
+
+		w.Header().Set("Content-Type", "application/json")
+		if len(requests) == 1 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"<bash>echo hello</bash>"}}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"It printed hello."}}]}`)
+	}))
+	defer server.Close()
+
+	root := t.TempDir()
+	config := &Config{
+		ProjectRoot: root,
+		SaaDir:      filepath.Join(root, ".saa"),
+		Settings:    Settings{APIURL: server.URL, Model: "test", TextTools: true},
+	}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+	agent := NewAgent(config, session)
+	if err := agent.Run(context.Background(), "say hello"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	for _, req := range requests {
+		if _, ok := req["tools"]; ok {
+			t.Errorf("expected no tools in the request")
+		}
+	}
+	var msgs []Message
+	json.Unmarshal(requests[1]["messages"], &msgs)
+	if len(msgs) != 4 || !strings.Contains(msgs[0].Content, "<bash>") || len(msgs[2].ToolCalls) != 0 {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if last := msgs[3]; last.Role != RoleUser || !strings.Contains(last.Content, "hello") {
+		t.Errorf("expected the result as a user message, got %+v", last)
+	}
+
+	// The session records a native tool call and result.
+	stored := session.Messages
+	if len(stored) != 5 || len(stored[2].ToolCalls) != 1 || stored[3].Role != RoleTool || stored[3].ToolCallID != stored[2].ToolCalls[0].ID {
+		t.Errorf("unexpected session messages %+v", stored)
+	}
+}
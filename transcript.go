@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// transcriptMessage is a session message prepared for display and export.
+type transcriptMessage struct {
+	Role       string               `json:"role"`
+	Content    string               `json:"content,omitempty"`
+	Reasoning  string               `json:"reasoning,omitempty"`
+	ToolCalls  []transcriptToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string               `json:"tool_call_id,omitempty"`
+	Model      string               `json:"model,omitempty"`
+	Logs       []transcriptLog      `json:"logs,omitempty"`
+}
+
+type transcriptToolCall struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Command string `json:"command"`
+}
+
+// transcriptLog is the full output of a tool result that was truncated.
+type transcriptLog struct {
+	Stream  string `json:"stream"`
+	ID      string `json:"id"`
+	Content string `json:"content"`
+}
+
+// truncatedLogRe matches the note handleOutput leaves in truncated results.
+var truncatedLogRe = regexp.MustCompile("`saa session (stdout|stderr) ([0-9]{8}-[0-9]{6}-[0-9a-f]{8})`")
+
+// loadTranscript reads every message of a session file, including the ones
+// hidden by compaction. With withLogs, the full logs of truncated tool
+// results are read from the session directory.
+func loadTranscript(path string, withLogs bool) ([]transcriptMessage, error) {
+	var msgs []transcriptMessage
+	err := scanSessionFile(path, func(typ string, line []byte) error {
+		if typ != "" {
+			return nil
+		}
+		msg, err := decodeMessage(line)
+		if err != nil {
+			return nil
+		}
+
+		m := transcriptMessage{
+			Role:       msg.Role,
+			Content:    msg.Content,
+			Reasoning:  msg.ReasoningContent,
+			ToolCallID: msg.ToolCallID,
+			Model:      msg.Model,
+		}
+		for _, tc := range msg.ToolCalls {
+			m.ToolCalls = append(m.ToolCalls, transcriptToolCall{
+				ID:      tc.ID,
+				Name:    tc.Function.Name,
+				Command: toolCallCommand(tc),
+			})
+		}
+		if withLogs && msg.Role == RoleTool {
+			m.Logs = readTruncatedLogs(filepath.Dir(path), msg.Content)
+		}
+		msgs = append(msgs, m)
+		return nil
+	})
+	return msgs, err
+}
+
+// toolCallCommand returns the command of a bash tool call, or the raw
+// arguments of anything else.
+func toolCallCommand(tc ToolCall) string {
+	var args struct {
+		Command string `json:"command"`
+	}
+	if tc.Function.Name == "bash" && json.Unmarshal([]byte(tc.Function.Arguments), &args) == nil {
+		return args.Command
+	}
+	return fmt.Sprintf("%s(%s)", tc.Function.Name, tc.Function.Arguments)
+}
+
+func readTruncatedLogs(dir, content string) []transcriptLog {
+	var logs []transcriptLog
+	for _, m := range truncatedLogRe.FindAllStringSubmatch(content, -1) {
+		data, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("%s.%s.log", m[2], m[1])))
+		if err != nil {
+			continue
+		}
+		logs = append(logs, transcriptLog{Stream: m[1], ID: m[2], Content: string(data)})
+	}
+	return logs
+}
+
+// resultSummary returns the first line of a tool result, which holds the
+// exit code for commands that ran.
+func resultSummary(content string) string {
+	first, _, _ := strings.Cut(content, "\n")
+	return first
+}
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiBold   = "\x1b[1m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// renderText writes msgs in the format Agent.Run prints them, with the user
+// prompts added. System prompts are only shown with withSystem.
+func renderText(w io.Writer, msgs []transcriptMessage, color, withSystem bool) {
+	header := func(style, label string) string {
+		if !color {
+			return label
+		}
+		return style + label + ansiReset
+	}
+	block := func(style, label, text string) {
+		fmt.Fprintln(w, header(style, label))
+		fmt.Fprint(w, text)
+		if !strings.HasSuffix(text, "\n") {
+			fmt.Fprintln(w)
+		}
+	}
+
+	for _, m := range msgs {
+		switch m.Role {
+		case RoleSystem:
+			if withSystem {
+				block(ansiDim, "[SYSTEM]", m.Content)
+			}
+		case RoleUser:
+			block(ansiBold+ansiGreen, "[USER]", m.Content)
+		case RoleTool:
+			block(ansiCyan, "[RESULT]", m.Content)
+		default:
+			if m.Reasoning != "" {
+				block(ansiDim, "[REASONING]", m.Reasoning)
+			}
+			if m.Content != "" {
+				block(ansiBold, "[MESSAGE]", m.Content)
+			}
+			for _, tc := range m.ToolCalls {
+				fmt.Fprintf(w, "%s %s\n", header(ansiYellow, "[TOOL]"), tc.Command)
+			}
+		}
+	}
+}
+
+// fence returns a Markdown code fence longer than any backtick run in s.
+func fence(s string) string {
+	longest, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(3, longest+1))
+}
+
+func codeBlock(w io.Writer, lang, s string) {
+	f := fence(s)
+	fmt.Fprintf(w, "%s%s\n%s", f, lang, s)
+	if !strings.HasSuffix(s, "\n") {
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%s\n", f)
+}
+
+// renderMarkdown writes msgs as Markdown. Reasoning and tool output are
+// folded into <details> blocks.
+func renderMarkdown(w io.Writer, title string, msgs []transcriptMessage) {
+	fmt.Fprintf(w, "# %s\n\n", title)
+	for _, m := range msgs {
+		switch m.Role {
+		case RoleSystem:
+			fmt.Fprint(w, "<details><summary>System prompt</summary>\n\n")
+			codeBlock(w, "text", m.Content)
+			fmt.Fprint(w, "\n</details>\n\n")
+		case RoleUser:
+			fmt.Fprintf(w, "## User\n\n%s\n\n", strings.TrimSpace(m.Content))
+		case RoleTool:
+			fmt.Fprintf(w, "<details><summary>Result: %s</summary>\n\n", template.HTMLEscapeString(resultSummary(m.Content)))
+			codeBlock(w, "text", m.Content)
+			for _, l := range m.Logs {
+				fmt.Fprintf(w, "\n<details><summary>Full %s (%d bytes)</summary>\n\n", l.Stream, len(l.Content))
+				codeBlock(w, "text", l.Content)
+				fmt.Fprint(w, "\n</details>\n")
+			}
+			fmt.Fprint(w, "\n</details>\n\n")
+		default:
+			fmt.Fprint(w, "## Assistant\n\n")
+			if m.Reasoning != "" {
+				fmt.Fprint(w, "<details><summary>Reasoning</summary>\n\n")
+				fmt.Fprintf(w, "%s\n\n</details>\n\n", strings.TrimSpace(m.Reasoning))
+			}
+			if m.Content != "" {
+				fmt.Fprintf(w, "%s\n\n", strings.TrimSpace(m.Content))
+			}
+			for _, tc := range m.ToolCalls {
+				codeBlock(w, "bash", tc.Command)
+				fmt.Fprintln(w)
+			}
+		}
+	}
+}
+
+var htmlTranscript = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"summary": resultSummary,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 2em auto; padding: 0 1em; }
+pre { background: #f4f4f4; padding: .5em; overflow-x: auto; white-space: pre-wrap; }
+.user { border-left: 4px solid #2a2; padding-left: .5em; }
+.assistant { border-left: 4px solid #888; padding-left: .5em; }
+.reasoning { color: #666; }
+summary { cursor: pointer; color: #336; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Messages}}{{if eq .Role "system"}}<details><summary>System prompt</summary><pre>{{.Content}}</pre></details>
+{{else if eq .Role "user"}}<div class="user"><h2>User</h2><pre>{{.Content}}</pre></div>
+{{else if eq .Role "tool"}}<details><summary>Result: {{summary .Content}}</summary><pre>{{.Content}}</pre>{{range .Logs}}
+<details><summary>Full {{.Stream}}</summary><pre>{{.Content}}</pre></details>{{end}}</details>
+{{else}}<div class="assistant"><h2>Assistant</h2>{{if .Reasoning}}<details class="reasoning"><summary>Reasoning</summary><pre>{{.Reasoning}}</pre></details>{{end}}{{if .Content}}<pre>{{.Content}}</pre>{{end}}{{range .ToolCalls}}
+<pre>$ {{.Command}}</pre>{{end}}</div>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// renderHTML writes msgs as a standalone HTML page.
+func renderHTML(w io.Writer, title string, msgs []transcriptMessage) error {
+	return htmlTranscript.Execute(w, struct {
+		Title    string
+		Messages []transcriptMessage
+	}{title, msgs})
+}
+
+// renderJSON writes msgs as an indented JSON document.
+func renderJSON(w io.Writer, title string, msgs []transcriptMessage) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Session  string              `json:"session"`
+		Messages []transcriptMessage `json:"messages"`
+	}{title, msgs})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func newTranscriptSession(t *testing.T) *Session {
+	t.Helper()
+	session := newTestSession(t)
+	agent := NewAgent(session.Config, session)
+
+	long := strings.Repeat("x", 20) + "<b>"
+	stdout, err := agent.handleOutput(long, 10, "stdout")
+	if err != nil {
+		t.Fatalf("handleOutput failed: %v", err)
+	}
+
+	for _, msg := range []Message{
+		{Role: RoleUser, Content: "print x"},
+		{Role: RoleAssistant, ReasoningContent: "easy", ToolCalls: []ToolCall{
+			{ID: "1", Function: FunctionCall{Name: "bash", Arguments: `{"command":"printf x"}`}},
+		}},
+		{Role: RoleTool, ToolCallID: "1", Content: "Exit Code: 0\nSTDOUT:\n" + stdout + "\nSTDERR:\n"},
+		{Role: RoleAssistant, Content: "Done."},
+	} {
+		if err := session.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+	return session
+}
+
+func TestRenderText(t *testing.T) {
+	session := newTranscriptSession(t)
+	msgs, err := loadTranscript(session.LogFile, false)
+	if err != nil {
+		t.Fatalf("loadTranscript failed: %v", err)
+	}
+
+	var out bytes.Buffer
+	renderText(&out, msgs, false, false)
+	text := out.String()
+	for _, want := range []string{"[USER]\nprint x\n", "[REASONING]\neasy\n", "[TOOL] printf x\n", "[RESULT]\nExit Code: 0\n", "[MESSAGE]\nDone.\n"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("expected %q in:\n%s", want, text)
+		}
+	}
+	if strings.Contains(text, "[SYSTEM]") || strings.Contains(text, "\x1b[") {
+		t.Errorf("expected no system prompt and no color, got:\n%s", text)
+	}
+
+	out.Reset()
+	renderText(&out, msgs, true, true)
+	if !strings.Contains(out.String(), "[SYSTEM]") || !strings.Contains(out.String(), ansiReset) {
+		t.Errorf("expected system prompt and color, got:\n%s", out.String())
+	}
+}
+
+func TestExport(t *testing.T) {
+	session := newTranscriptSession(t)
+	msgs, err := loadTranscript(session.LogFile, true)
+	if err != nil {
+		t.Fatalf("loadTranscript failed: %v", err)
+	}
+	if len(msgs[3].Logs) != 1 || !strings.HasSuffix(msgs[3].Logs[0].Content, "x<b>") {
+		t.Fatalf("expected the full stdout log to be inlined, got %+v", msgs[3].Logs)
+	}
+
+	var md bytes.Buffer
+	renderMarkdown(&md, "test", msgs)
+	for _, want := range []string{"# test\n", "## User\n\nprint x\n", "```bash\nprintf x\n```", "<summary>Result: Exit Code: 0</summary>", "<summary>Full stdout (23 bytes)</summary>"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("expected %q in markdown:\n%s", want, md.String())
+		}
+	}
+
+	var html bytes.Buffer
+	if err := renderHTML(&html, "test", msgs); err != nil {
+		t.Fatalf("renderHTML failed: %v", err)
+	}
+	if !strings.Contains(html.String(), "x&lt;b&gt;") || strings.Contains(html.String(), "x<b>") {
+		t.Errorf("expected escaped output in HTML")
+	}
+
+	var js bytes.Buffer
+	if err := renderJSON(&js, "test", msgs); err != nil {
+		t.Fatalf("renderJSON failed: %v", err)
+	}
+	var doc struct {
+		Session  string              `json:"session"`
+		Messages []transcriptMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(js.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON export: %v", err)
+	}
+	if doc.Session != "test" || len(doc.Messages) != 5 || doc.Messages[2].ToolCalls[0].Command != "printf x" {
+		t.Errorf("unexpected JSON export %+v", doc)
+	}
+}
+
+func TestFence(t *testing.T) {
+	if f := fence("no ticks"); f != "```" {
+		t.Errorf("unexpected fence %q", f)
+	}
+	if f := fence("has ```` ticks"); f != "`````" {
+		t.Errorf("unexpected fence %q", f)
+	}
+}
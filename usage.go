@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ModelPrice is the price of a model in dollars per million tokens.
+// CachedInput defaults to Input when it is not set.
+type ModelPrice struct {
+	Input       float64 `mapstructure:"input" json:"input"`
+	CachedInput float64 `mapstructure:"cached_input" json:"cached_input,omitempty"`
+	Output      float64 `mapstructure:"output" json:"output"`
+}
+
+// priceFor looks up the price of model. Keys are matched case-insensitively
+// because the config loader lowercases them.
+func priceFor(prices map[string]ModelPrice, model string) (ModelPrice, bool) {
+	if p, ok := prices[model]; ok {
+		return p, true
+	}
+	p, ok := prices[strings.ToLower(model)]
+	return p, ok
+}
+
+// cost returns the estimated cost of ev in dollars.
+func (p ModelPrice) cost(ev UsageEvent) float64 {
+	cachedPrice := p.CachedInput
+	if cachedPrice == 0 {
+		cachedPrice = p.Input
+	}
+	uncached := ev.PromptTokens - ev.CachedTokens
+	return (float64(uncached)*p.Input + float64(ev.CachedTokens)*cachedPrice + float64(ev.CompletionTokens)*p.Output) / 1e6
+}
+
+func newUsageEvent(model string, usage Usage, latency time.Duration) UsageEvent {
+	ev := UsageEvent{
+		Type:             EventUsage,
+		Time:             time.Now(),
+		Model:            model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		TotalTokens:      usage.TotalTokens,
+		CachedTokens:     usage.CachedTokens,
+		ReasoningTokens:  usage.ReasoningTokens,
+		LatencyMS:        latency.Milliseconds(),
+	}
+	return ev
+}
+
+// usageTotals sums the usage of several requests.
+type usageTotals struct {
+	Requests   int
+	ToolCalls  int
+	Prompt     int
+	Cached     int
+	Completion int
+	Reasoning  int
+	Total      int
+	Latency    time.Duration
+
+	Cost     float64
+	Unpriced int // requests whose model has no price
+}
+
+func (t *usageTotals) add(ev UsageEvent, prices map[string]ModelPrice) {
+	t.Requests++
+	t.Prompt += ev.PromptTokens
+	t.Cached += ev.CachedTokens
+	t.Completion += ev.CompletionTokens
+	t.Reasoning += ev.ReasoningTokens
+	t.Total += ev.TotalTokens
+	t.Latency += time.Duration(ev.LatencyMS) * time.Millisecond
+	if p, ok := priceFor(prices, ev.Model); ok {
+		t.Cost += p.cost(ev)
+	} else {
+		t.Unpriced++
+	}
+}
+
+// costString formats the estimated cost, or "n/a" if no request was priced.
+func (t *usageTotals) costString() string {
+	if t.Unpriced == t.Requests {
+		return "n/a"
+	}
+	s := fmt.Sprintf("$%.4f", t.Cost)
+	if t.Unpriced > 0 {
+		s += fmt.Sprintf(" (%d requests without a price)", t.Unpriced)
+	}
+	return s
+}
+
+// summary returns a one-line summary for the end of a run.
+func (t *usageTotals) summary() string {
+	return fmt.Sprintf("Usage: %d requests, %d tool calls, %d tokens (prompt %d, completion %d), %s, cost %s",
+		t.Requests, t.ToolCalls, t.Total, t.Prompt, t.Completion, t.Latency.Round(100*time.Millisecond), t.costString())
+}
+
+// usagePath returns the sidecar holding the usage events of a session
+// file. They are kept apart so that the session file holds messages and
+// the few events that change them.
+func usagePath(logFile string) string {
+	return strings.TrimSuffix(logFile, ".jsonl") + ".usage"
+}
+
+// scanUsage calls fn for every usage event of a session file: those of its
+// sidecar, after any that older versions wrote into the file itself.
+func scanUsage(logFile string, fn func(ev UsageEvent)) error {
+	err := scanSessionFile(logFile, func(typ string, line []byte) error {
+		if typ == EventUsage {
+			if ev, err := decodeEvent[UsageEvent](line); err == nil {
+				fn(ev)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = eachLine(usagePath(logFile), func(line []byte) error {
+		if ev, err := decodeEvent[UsageEvent](line); err == nil && ev.Type == EventUsage {
+			fn(ev)
+		}
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// SessionStats is the usage recorded for a session file.
+type SessionStats struct {
+	Events    []UsageEvent
+	ToolCalls int
+}
+
+// ReadSessionStats reads the usage events and counts the tool calls of a
+// session file.
+func ReadSessionStats(path string) (*SessionStats, error) {
+	stats := &SessionStats{}
+	err := scanSessionFile(path, func(typ string, line []byte) error {
+		if typ == "" {
+			msg, err := decodeMessage(line)
+			if err == nil {
+				stats.ToolCalls += len(msg.ToolCalls)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	err = scanUsage(path, func(ev UsageEvent) {
+		stats.Events = append(stats.Events, ev)
+	})
+	return stats, err
+}
+
+// Print writes the totals and a per-request breakdown to w.
+func (s *SessionStats) Print(w io.Writer, prices map[string]ModelPrice) {
+	totals := usageTotals{ToolCalls: s.ToolCalls}
+	for _, ev := range s.Events {
+		totals.add(ev, prices)
+	}
+
+	fmt.Fprintf(w, "Requests:    %d\n", totals.Requests)
+	fmt.Fprintf(w, "Tool calls:  %d\n", totals.ToolCalls)
+	fmt.Fprintf(w, "Prompt:      %d tokens (%d cached)\n", totals.Prompt, totals.Cached)
+	fmt.Fprintf(w, "Completion:  %d tokens (%d reasoning)\n", totals.Completion, totals.Reasoning)
+	fmt.Fprintf(w, "Total:       %d tokens\n", totals.Total)
+	fmt.Fprintf(w, "Latency:     %s\n", totals.Latency.Round(100*time.Millisecond))
+	fmt.Fprintf(w, "Cost:        %s\n", totals.costString())
+
+	if len(s.Events) == 0 {
+		return
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "#\tMODEL\tPROMPT\tCACHED\tCOMPLETION\tREASONING\tLATENCY\tCOST\t")
+	for i, ev := range s.Events {
+		cost := "n/a"
+		if p, ok := priceFor(prices, ev.Model); ok {
+			cost = fmt.Sprintf("$%.4f", p.cost(ev))
+		}
+		model := ev.Model
+		if ev.Purpose != "" {
+			model += " (" + ev.Purpose + ")"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t\n",
+			i+1, model, ev.PromptTokens, ev.CachedTokens, ev.CompletionTokens, ev.ReasoningTokens,
+			(time.Duration(ev.LatencyMS) * time.Millisecond).Round(100*time.Millisecond), cost)
+	}
+	tw.Flush()
+}
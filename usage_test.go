@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestModelPriceCost(t *testing.T) {
+	p := ModelPrice{Input: 2, CachedInput: 0.5, Output: 8}
+	ev := UsageEvent{PromptTokens: 1_000_000, CachedTokens: 400_000, CompletionTokens: 100_000}
+	// 600k uncached * 2 + 400k cached * 0.5 + 100k * 8, per million.
+	if cost := p.cost(ev); math.Abs(cost-2.2) > 1e-9 {
+		t.Errorf("expected cost 2.2, got %v", cost)
+	}
+
+	if _, ok := priceFor(map[string]ModelPrice{"gpt-4o": p}, "GPT-4o"); !ok {
+		t.Errorf("expected the price lookup to ignore case")
+	}
+}
+
+func TestSessionStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &Config{SaaDir: filepath.Join(tmpDir, ".saa")}
+	session := NewSession(config)
+	if err := session.NewSession(); err != nil {
+		t.Fatalf("NewSession failed: %v", err)
+	}
+
+	agent := NewAgent(config, session)
+	usage := Usage{
+		PromptTokens:     100,
+		CompletionTokens: 20,
+		TotalTokens:      120,
+		CachedTokens:     50,
+		ReasoningTokens:  5,
+	}
+	for _, msg := range []Message{
+		{Role: RoleUser, Content: "list"},
+		{Role: RoleAssistant, ToolCalls: []ToolCall{{ID: "1"}, {ID: "2"}}},
+	} {
+		if err := session.AddMessage(msg); err != nil {
+			t.Fatalf("AddMessage failed: %v", err)
+		}
+	}
+	if err := agent.recordUsage("", usage, 1500*time.Millisecond, ""); err != nil {
+		t.Fatalf("recordUsage failed: %v", err)
+	}
+	if err := agent.recordUsage("", usage, 500*time.Millisecond, "compaction"); err != nil {
+		t.Fatalf("recordUsage failed: %v", err)
+	}
+
+	// Usage is kept out of the session file.
+	if data, err := os.ReadFile(session.LogFile); err != nil || strings.Contains(string(data), EventUsage) {
+		t.Errorf("expected no usage lines in the session file, got %q (%v)", data, err)
+	}
+	reloaded := NewSession(config)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if len(reloaded.Messages) != 3 {
+		t.Errorf("expected 3 messages, got %d", len(reloaded.Messages))
+	}
+
+	stats, err := ReadSessionStats(session.LogFile)
+	if err != nil {
+		t.Fatalf("ReadSessionStats failed: %v", err)
+	}
+	if len(stats.Events) != 2 || stats.ToolCalls != 2 {
+		t.Fatalf("expected 2 usage events and 2 tool calls, got %d and %d", len(stats.Events), stats.ToolCalls)
+	}
+	if ev := stats.Events[0]; ev.CachedTokens != 50 || ev.ReasoningTokens != 5 || ev.LatencyMS != 1500 {
+		t.Errorf("unexpected usage event %+v", ev)
+	}
+
+	var out bytes.Buffer
+	stats.Print(&out, map[string]ModelPrice{"": {Input: 1, Output: 1}})
+	for _, want := range []string{"Requests:    2", "Tool calls:  2", "Prompt:      200 tokens (100 cached)", "Latency:     2s", "Cost:        $0.0002", "(compaction)"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	if summary := agent.Usage(); !strings.HasPrefix(summary, "Usage: 2 requests") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}